@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/jordanpotter/site-analyzer/browser"
+	"github.com/jordanpotter/site-analyzer/budget"
+	"github.com/jordanpotter/site-analyzer/utils"
+	"github.com/jordanpotter/site-analyzer/video"
+)
+
+const (
+	aggregateFilename = "aggregate.json"
+	runDirnameFormat  = "run-%d"
+	representativeKey = "pageLoadTimeMs"
+)
+
+// aggregate summarizes repeated runs of the same url. Runs are numbered from
+// one, matching their subdirectories.
+type aggregate struct {
+	URL               string                     `json:"url"`
+	Runs              int                        `json:"runs"`
+	SuccessfulRuns    int                        `json:"successfulRuns"`
+	RepresentativeRun int                        `json:"representativeRun,omitempty"`
+	Video             string                     `json:"video,omitempty"`
+	Metrics           map[string]statistics      `json:"metrics"`
+	Errors            map[int]string             `json:"errors,omitempty"`
+	BudgetViolations  map[int][]budget.Violation `json:"budgetViolations,omitempty"`
+}
+
+type statistics struct {
+	Median float64 `json:"median"`
+	P75    float64 `json:"p75"`
+	P95    float64 `json:"p95"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	StdDev float64 `json:"stdDev"`
+}
+
+// runRepeatedly runs the full analysis n times, each in its own numbered
+// subdirectory, and aggregates the metrics of the runs that succeeded. The
+// median run by page load time is chosen as the representative run. Any run
+// failing fails the whole, and otherwise the budget violations of every run
+// are returned.
+func runRepeatedly(j *job, n int) error {
+	agg := &aggregate{
+		URL:              j.URL,
+		Runs:             n,
+		Metrics:          make(map[string]statistics),
+		Errors:           make(map[int]string),
+		BudgetViolations: make(map[int][]budget.Violation),
+	}
+
+	results := make(map[int]*runResult)
+	var violations []budget.Violation
+	for i := 1; i <= n; i++ {
+		name := fmt.Sprintf(runDirnameFormat, i)
+		rj := *j
+		rj.dir = filepath.Join(j.dir, name)
+		rj.log = log.New(os.Stderr, fmt.Sprintf("%s[%s] ", j.log.Prefix(), name), j.log.Flags())
+
+		if err := os.MkdirAll(rj.dir, 0755); err != nil {
+			return errors.Wrapf(err, "failed to create directory %s", rj.dir)
+		}
+
+		result, err := runOnce(&rj)
+		if budgetErr, ok := err.(*budgetExceededError); ok {
+			agg.BudgetViolations[i] = budgetErr.violations
+			for _, violation := range budgetErr.violations {
+				violation.Message = fmt.Sprintf("run %d: %s", i, violation.Message)
+				violations = append(violations, violation)
+			}
+		} else if err != nil {
+			rj.log.Printf("Unexpected error: %v", err)
+			agg.Errors[i] = err.Error()
+			continue
+		}
+		results[i] = result
+	}
+
+	agg.SuccessfulRuns = len(results)
+	if agg.SuccessfulRuns == 0 {
+		if _, err := agg.save(j.dir); err != nil {
+			j.log.Printf("Unexpected error while saving aggregate: %v", err)
+		}
+		return errors.Errorf("all %d runs failed", n)
+	}
+
+	values := make(map[string][]float64)
+	loadTimes := make(map[int]float64)
+	for i, result := range results {
+		for name, value := range collectMetrics(result.analysis, result.visualProgress) {
+			values[name] = append(values[name], value)
+			if name == representativeKey {
+				loadTimes[i] = value
+			}
+		}
+	}
+
+	for name, v := range values {
+		agg.Metrics[name] = computeStatistics(v)
+	}
+
+	agg.RepresentativeRun = medianRun(loadTimes)
+	j.log.Printf("Run %d is the median run", agg.RepresentativeRun)
+
+	videoPath := filepath.Join(j.dir, filepath.Base(results[agg.RepresentativeRun].videoPath))
+	if err := copyFile(results[agg.RepresentativeRun].videoPath, videoPath); err != nil {
+		return errors.Wrap(err, "failed to copy representative video")
+	}
+	agg.Video = filepath.Base(videoPath)
+
+	aggregatePath, err := agg.save(j.dir)
+	if err != nil {
+		return errors.Wrap(err, "failed to save aggregate")
+	}
+
+	stats := agg.Metrics[representativeKey]
+	j.log.Printf("Page load time median %.0fms, p75 %.0fms, p95 %.0fms, min %.0fms, max %.0fms, std dev %.0fms",
+		stats.Median, stats.P75, stats.P95, stats.Min, stats.Max, stats.StdDev)
+	j.log.Printf("Aggregate saved to %s", aggregatePath)
+	j.log.Printf("Representative video saved to %s", videoPath)
+
+	if len(agg.Errors) > 0 {
+		return errors.Errorf("%d of %d runs failed", len(agg.Errors), n)
+	} else if len(violations) > 0 {
+		return &budgetExceededError{violations}
+	}
+	return nil
+}
+
+// collectMetrics flattens the measurements of a run into named values, with
+// durations in milliseconds. Network metrics are left out when the browser did
+// not measure them. Timings the browser did not report are zero and
+// are left out, so that they do not skew the statistics.
+func collectMetrics(analysis *browser.Analysis, visualProgress *video.VisualProgress) map[string]float64 {
+	metrics := map[string]float64{
+		"pageLoadTimeMs":   milliseconds(analysis.PageLoadTime),
+		"consoleLogErrors": float64(len(analysis.ConsoleLog.Errors())),
+	}
+
+	if analysis.NetworkMeasured {
+		var requests, blockedRequests int
+		var transferBytes int64
+		for _, entry := range analysis.Waterfall {
+			if entry.Blocked {
+				blockedRequests++
+				continue
+			}
+			requests++
+			transferBytes += entry.TransferSize
+		}
+		metrics["requests"] = float64(requests)
+		metrics["blockedRequests"] = float64(blockedRequests)
+		metrics["transferBytes"] = float64(transferBytes)
+	}
+
+	timings := make(map[string]time.Duration)
+	if m := analysis.Metrics; m != nil {
+		timings["firstPaintMs"] = m.FirstPaint
+		timings["firstContentfulPaintMs"] = m.FirstContentfulPaint
+		timings["largestContentfulPaintMs"] = m.LargestContentfulPaint
+		timings["domContentLoadedMs"] = m.DOMContentLoaded
+		timings["loadEventMs"] = m.LoadEvent
+		metrics["cumulativeLayoutShift"] = m.CumulativeLayoutShift
+	}
+
+	if visualProgress != nil {
+		timings["speedIndexMs"] = visualProgress.SpeedIndex
+		timings["firstVisualChangeMs"] = visualProgress.FirstVisualChange
+		timings["lastVisualChangeMs"] = visualProgress.LastVisualChange
+	}
+
+	for name, timing := range timings {
+		if timing > 0 {
+			metrics[name] = milliseconds(timing)
+		}
+	}
+
+	return metrics
+}
+
+func computeStatistics(values []float64) statistics {
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	var sum float64
+	for _, v := range sorted {
+		sum += v
+	}
+	mean := sum / float64(len(sorted))
+
+	var variance float64
+	for _, v := range sorted {
+		variance += (v - mean) * (v - mean)
+	}
+	variance /= float64(len(sorted))
+
+	return statistics{
+		Median: percentile(sorted, 50),
+		P75:    percentile(sorted, 75),
+		P95:    percentile(sorted, 95),
+		Min:    sorted[0],
+		Max:    sorted[len(sorted)-1],
+		StdDev: math.Sqrt(variance),
+	}
+}
+
+// percentile linearly interpolates between the closest ranks of sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	return sorted[lower] + (rank-float64(lower))*(sorted[upper]-sorted[lower])
+}
+
+// medianRun returns the run with the median value, preferring the faster of
+// the two middle runs when there is an even number.
+func medianRun(values map[int]float64) int {
+	runs := make([]int, 0, len(values))
+	for run := range values {
+		runs = append(runs, run)
+	}
+	sort.Slice(runs, func(i, j int) bool {
+		if values[runs[i]] == values[runs[j]] {
+			return runs[i] < runs[j]
+		}
+		return values[runs[i]] < values[runs[j]]
+	})
+	return runs[(len(runs)-1)/2]
+}
+
+func (agg *aggregate) save(dir string) (string, error) {
+	path := filepath.Join(dir, aggregateFilename)
+	f, err := os.Create(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to create file %s", path)
+	}
+	defer utils.MustFunc(f.Close)
+
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	if err = encoder.Encode(agg); err != nil {
+		return "", errors.Wrapf(err, "failed to write json to file %s", path)
+	}
+
+	return path, nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open file %s", src)
+	}
+	defer utils.MustFunc(in.Close)
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create file %s", dst)
+	}
+	defer utils.MustFunc(out.Close)
+
+	_, err = io.Copy(out, in)
+	return errors.Wrapf(err, "failed to copy %s to %s", src, dst)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/jordanpotter/site-analyzer/utils"
+	"github.com/pkg/errors"
+)
+
+const maxJobDirnameLength = 80
+
+var unsafeDirnameChars = regexp.MustCompile(`[^a-zA-Z0-9.-]+`)
+
+// readJobs parses a file with one url per line. Lines starting with "{" are
+// treated as json objects that can also override the per-url options. Blank
+// lines and lines starting with "#" are ignored.
+func readJobs(path string) ([]*job, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open file %s", path)
+	}
+	defer utils.MustFunc(f.Close)
+
+	var jobs []*job
+	recordLines := make(map[string]int)
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		j := &job{URL: line}
+		if strings.HasPrefix(line, "{") {
+			j = &job{}
+			if err = json.Unmarshal([]byte(line), j); err != nil {
+				return nil, errors.Wrapf(err, "failed to unmarshal line %d", lineNum)
+			}
+		}
+
+		if j.URL == "" {
+			return nil, errors.Errorf("missing url on line %d", lineNum)
+		}
+
+		// Concurrent jobs recording to one archive would overwrite each other
+		if j.Record != "" {
+			record := filepath.Clean(j.Record)
+			if prev, ok := recordLines[record]; ok {
+				return nil, errors.Errorf("line %d records to the same archive as line %d", lineNum, prev)
+			}
+			recordLines[record] = lineNum
+		}
+
+		jobs = append(jobs, j)
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "failed to read file %s", path)
+	}
+
+	return jobs, nil
+}
+
+// runBatch analyzes the jobs with a pool of workers, each writing into its own
+// subdirectory of the data directory, and returns the number that failed and
+// the number that exceeded their budget.
+func runBatch(jobs []*job, workers int) (int, int) {
+	c := make(chan *job)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failed, overBudget := 0, 0
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range c {
+				err := runBatchJob(j)
+				mu.Lock()
+				if isBudgetExceeded(err) {
+					j.log.Printf("%v", err)
+					overBudget++
+				} else if err != nil {
+					j.log.Printf("Unexpected error: %v", err)
+					failed++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for i, j := range jobs {
+		name := jobDirname(i, j.URL)
+		j.dir = filepath.Join(dataDir, name)
+		j.log = log.New(os.Stderr, fmt.Sprintf("[%s] ", name), log.LstdFlags)
+		c <- j
+	}
+	close(c)
+
+	wg.Wait()
+	return failed, overBudget
+}
+
+func runBatchJob(j *job) error {
+	if err := os.MkdirAll(j.dir, 0755); err != nil {
+		return errors.Wrapf(err, "failed to create directory %s", j.dir)
+	}
+	return run(j)
+}
+
+func jobDirname(index int, url string) string {
+	name := strings.TrimPrefix(strings.TrimPrefix(url, "http://"), "https://")
+	name = strings.Trim(unsafeDirnameChars.ReplaceAllString(name, "_"), "_")
+	if len(name) > maxJobDirnameLength {
+		name = name[:maxJobDirnameLength]
+	}
+	return fmt.Sprintf("%03d-%s", index+1, name)
+}
@@ -7,10 +7,20 @@ import (
 	"github.com/pkg/errors"
 )
 
+const blankURL = "about:blank"
+
+// Analysis is what was measured while loading a page. CPUThrottlingRate is
+// how many times slower the CPU was made, where 1 is unthrottled.
+// NetworkMeasured is whether the browser reported its network activity, as
+// the performance log and waterfall are otherwise empty.
 type Analysis struct {
-	PageLoadTime   time.Duration
-	ConsoleLog     *ConsoleLog
-	PerformanceLog *PerformanceLog
+	PageLoadTime      time.Duration
+	Metrics           *PageMetrics
+	ConsoleLog        *ConsoleLog
+	PerformanceLog    *PerformanceLog
+	Waterfall         []WaterfallEntry
+	CPUThrottlingRate float64
+	NetworkMeasured   bool
 }
 
 func (b *Browser) Analyze(ctx context.Context, url string, loadedSpec *LoadedSpec, postPageLoadSleep time.Duration) (*Analysis, error) {
@@ -39,6 +49,11 @@ func (b *Browser) doAnalysis(url string, loadedSpec *LoadedSpec, postPageLoadSle
 
 	time.Sleep(postPageLoadSleep)
 
+	metrics, err := b.pageMetrics()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get page metrics")
+	}
+
 	consoleLog, err := b.consoleLog()
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get console log")
@@ -49,9 +64,57 @@ func (b *Browser) doAnalysis(url string, loadedSpec *LoadedSpec, postPageLoadSle
 		return nil, errors.Wrap(err, "failed to get performance log")
 	}
 
+	consoleLog.setOffsets(metrics.NavigationStart)
+	performanceLog.setOffsets(metrics.NavigationStart)
+
+	waterfall, err := performanceLog.Waterfall()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to compute network waterfall")
+	}
+
 	return &Analysis{
-		PageLoadTime:   pageLoadTime,
-		ConsoleLog:     consoleLog,
-		PerformanceLog: performanceLog,
+		PageLoadTime:      pageLoadTime,
+		Metrics:           metrics,
+		ConsoleLog:        consoleLog,
+		PerformanceLog:    performanceLog,
+		Waterfall:         waterfall,
+		CPUThrottlingRate: b.cpuThrottlingRate,
+		NetworkMeasured:   b.engine.supportsPerformanceLog(),
 	}, nil
 }
+
+// NavigateAway loads a blank page and discards the logs collected so far, so
+// that the next analysis only includes its own entries. The session keeps its
+// cache, making a following analysis of the same url a repeat view.
+func (b *Browser) NavigateAway(ctx context.Context) error {
+	var err error
+
+	c := make(chan bool, 1)
+	go func() {
+		err = b.doNavigateAway()
+		c <- true
+	}()
+
+	select {
+	case <-c:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *Browser) doNavigateAway() error {
+	if err := b.session.Url(blankURL); err != nil {
+		return errors.Wrap(err, "failed to set url")
+	}
+
+	if _, err := b.session.Log(consoleLogName); err != nil {
+		return errors.Wrap(err, "failed to discard console log")
+	}
+
+	if _, err := b.performanceLog(); err != nil {
+		return errors.Wrap(err, "failed to discard performance log")
+	}
+
+	return nil
+}
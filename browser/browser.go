@@ -1,7 +1,16 @@
 package browser
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
 	"github.com/fedesog/webdriver"
+	"github.com/jordanpotter/site-analyzer/utils"
 	"github.com/pkg/errors"
 )
 
@@ -10,9 +19,180 @@ const (
 	asyncScriptTimeoutMs  = 60000
 )
 
+// unknownCommandStatus is the status the JSON wire protocol gives a command the
+// driver does not implement.
+const unknownCommandStatus = 9
+
+const windowDecorationScript = `return [window.outerWidth - window.innerWidth, window.outerHeight - window.innerHeight];`
+
+// Options configure a browser regardless of its engine. The window is Width by
+// Height, unless a Device is given, in which case the window is sized to fit
+// the device's viewport along with the browser's toolbar. Network
+// conditions, the CPU throttling rate, the user agent and the extra headers
+// apply to every page the browser loads, where a rate of 4 makes the CPU four
+// times slower. The user agent takes precedence over the device's. Requests
+// to urls matching BlockedURLs, where * is a wildcard, are blocked. With a
+// Proxy, given as host:port, every request goes through it and certificate
+// errors are ignored, so that the proxy may intercept HTTPS. Hosts maps
+// hostnames to the IP addresses to connect to in their place.
+type Options struct {
+	Width      int
+	Height     int
+	DisplayNum int
+	LogsDir    string
+	Device     *Device
+	Network    *NetworkConditions
+
+	CPUThrottlingRate float64
+
+	UserAgent   string
+	Headers     map[string]string
+	BlockedURLs []string
+	Proxy       string
+	Hosts       map[string]string
+}
+
+// engine is the behaviour that differs between the browsers driven through
+// webdriver.
+type engine interface {
+	name() string
+	driverVersion(caps webdriver.Capabilities) string
+	supportsPerformanceLog() bool
+	supportsRemoteCookies() bool
+	command(session *webdriver.Session, path string, params interface{}) error
+	query(session *webdriver.Session, path string, params, value interface{}) error
+	setNetworkConditions(session *webdriver.Session, nc *NetworkConditions) error
+	setCPUThrottlingRate(session *webdriver.Session, rate float64) error
+	setExtraHeaders(session *webdriver.Session, headers map[string]string) error
+	setBlockedURLs(session *webdriver.Session, patterns []string) error
+	setRemoteCookie(session *webdriver.Session, origin string, cookie Cookie) error
+	windowDecoration(session *webdriver.Session, device *Device) (int, int, error)
+}
+
+// Viewport is the region of the display that pages are rendered in.
+type Viewport struct {
+	X      int
+	Y      int
+	Width  int
+	Height int
+}
+
 type Browser struct {
-	webDriver webdriver.WebDriver
-	session   *webdriver.Session
+	webDriver         webdriver.WebDriver
+	session           *webdriver.Session
+	engine            engine
+	cpuThrottlingRate float64
+	viewport          Viewport
+}
+
+func newBrowser(webDriver webdriver.WebDriver, session *webdriver.Session, e engine, opts *Options) (*Browser, error) {
+	b := &Browser{webDriver, session, e, 1, Viewport{0, 0, opts.Width, opts.Height}}
+
+	if err := session.SetTimeoutsImplicitWait(implicitWaitTimeoutMs); err != nil {
+		return nil, errors.Wrap(err, "failed to set implicit wait timeout")
+	}
+
+	if err := session.SetTimeoutsAsyncScript(asyncScriptTimeoutMs); err != nil {
+		return nil, errors.Wrap(err, "failed to set async script timeout")
+	}
+
+	if opts.Network != nil {
+		if err := e.setNetworkConditions(session, opts.Network); err != nil {
+			return nil, errors.Wrap(err, "failed to set network conditions")
+		}
+	}
+
+	if opts.CPUThrottlingRate > 1 {
+		if err := e.setCPUThrottlingRate(session, opts.CPUThrottlingRate); err != nil {
+			return nil, errors.Wrap(err, "failed to set cpu throttling rate")
+		}
+		b.cpuThrottlingRate = opts.CPUThrottlingRate
+	}
+
+	if len(opts.Headers) > 0 {
+		if err := e.setExtraHeaders(session, opts.Headers); err != nil {
+			return nil, errors.Wrap(err, "failed to set extra headers")
+		}
+	}
+
+	if len(opts.BlockedURLs) > 0 {
+		if err := e.setBlockedURLs(session, opts.BlockedURLs); err != nil {
+			return nil, errors.Wrap(err, "failed to set blocked urls")
+		}
+	}
+
+	window, err := session.WindowHandle()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get window handle")
+	}
+
+	if err := window.SetPosition(webdriver.Position{X: 0, Y: 0}); err != nil {
+		return nil, errors.Wrap(err, "failed to set window position")
+	}
+
+	if err := window.SetSize(webdriver.Size{Width: opts.Width, Height: opts.Height}); err != nil {
+		return nil, errors.Wrap(err, "failed to set window size")
+	}
+
+	if opts.Device != nil {
+		if err := b.fitDevice(window, opts); err != nil {
+			return nil, errors.Wrapf(err, "failed to fit device %s", opts.Device.Name)
+		}
+	}
+
+	return b, nil
+}
+
+// fitDevice sizes the window around the device's viewport, and locates the
+// viewport on the display. Browsers have a minimum window width, so a narrow
+// device may leave space to the right of its viewport.
+func (b *Browser) fitDevice(window webdriver.WindowHandle, opts *Options) error {
+	decorationWidth, decorationHeight, err := b.engine.windowDecoration(b.session, opts.Device)
+	if err != nil {
+		return errors.Wrap(err, "failed to measure window decoration")
+	}
+
+	// Any border is assumed to be even around the window, below the toolbar
+	viewport := Viewport{
+		X:      decorationWidth / 2,
+		Y:      decorationHeight - decorationWidth/2,
+		Width:  opts.Device.Width,
+		Height: opts.Device.Height,
+	}
+	if viewport.X+viewport.Width > opts.Width || viewport.Y+viewport.Height > opts.Height {
+		return errors.Errorf("viewport does not fit in a %dx%d display", opts.Width, opts.Height)
+	}
+
+	size := webdriver.Size{Width: opts.Device.Width + decorationWidth, Height: opts.Device.Height + decorationHeight}
+	if err = window.SetSize(size); err != nil {
+		return errors.Wrap(err, "failed to set window size")
+	}
+
+	b.viewport = viewport
+	return nil
+}
+
+// measureWindowDecoration is how much wider and taller the window is than the
+// page inside it.
+func measureWindowDecoration(session *webdriver.Session) (int, int, error) {
+	data, err := session.ExecuteScript(windowDecorationScript, []interface{}{})
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed to execute script")
+	}
+
+	var decoration []int
+	if err = json.Unmarshal(data, &decoration); err != nil {
+		return 0, 0, errors.Wrapf(err, "failed to unmarshal %q", data)
+	} else if len(decoration) != 2 {
+		return 0, 0, errors.Errorf("unexpected window decoration %q", data)
+	}
+	return decoration[0], decoration[1], nil
+}
+
+// Viewport is where pages are rendered on the display. Without a device it is
+// the whole window.
+func (b *Browser) Viewport() Viewport {
+	return b.viewport
 }
 
 func (b *Browser) Close() error {
@@ -21,8 +201,103 @@ func (b *Browser) Close() error {
 	}
 
 	if err := b.webDriver.Stop(); err != nil {
-		return errors.Wrap(err, "failed to stop chromedriver")
+		return errors.Wrapf(err, "failed to stop %s webdriver", b.engine.name())
 	}
 
 	return nil
 }
+
+// Name is the name of the browser engine.
+func (b *Browser) Name() string {
+	return b.engine.name()
+}
+
+// Version is the browser version reported when the session was created.
+func (b *Browser) Version() string {
+	for _, key := range []string{"browserVersion", "version"} {
+		if version, ok := b.session.Capabilities[key].(string); ok {
+			return version
+		}
+	}
+	return ""
+}
+
+// DriverVersion is the webdriver version reported when the session was
+// created.
+func (b *Browser) DriverVersion() string {
+	return b.engine.driverVersion(b.session.Capabilities)
+}
+
+func startTimeout(ctx context.Context, defaultTimeout time.Duration) (time.Duration, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return defaultTimeout, nil
+	} else if deadline.Before(time.Now()) {
+		return 0, errors.New("context deadline exceeded")
+	}
+	return deadline.Sub(time.Now()), nil
+}
+
+// postCommand posts a command for the session directly to the driver at
+// driverURL, for the commands the webdriver package does not expose.
+func postCommand(driverURL string, session *webdriver.Session, path string, params interface{}) error {
+	return postQuery(driverURL, session, path, params, nil)
+}
+
+// postQuery posts a command like postCommand, and unmarshals the value the
+// driver responds with into value, for the responses the webdriver package
+// does not fully decode.
+func postQuery(driverURL string, session *webdriver.Session, path string, params, value interface{}) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal params")
+	}
+
+	url := fmt.Sprintf("%s/session/%s/%s", driverURL, session.Id, path)
+	resp, err := http.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return errors.Wrapf(err, "failed to post %s", path)
+	}
+	defer utils.MustFunc(resp.Body.Close)
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrap(err, "failed to read response")
+	}
+
+	var result struct {
+		Status int             `json:"status"`
+		Value  json.RawMessage `json:"value"`
+	}
+	if err = json.Unmarshal(body, &result); err != nil {
+		return errors.Wrapf(err, "failed to unmarshal %q", body)
+	}
+
+	if resp.StatusCode >= 400 || result.Status != 0 {
+		var failure struct {
+			Error string `json:"error"`
+		}
+		if result.Status == unknownCommandStatus ||
+			(json.Unmarshal(result.Value, &failure) == nil && failure.Error == "unknown command") {
+			return &unknownCommandError{path}
+		}
+		return errors.Errorf("unexpected response to %s: %s", path, body)
+	}
+
+	if value != nil {
+		if err = json.Unmarshal(result.Value, value); err != nil {
+			return errors.Wrapf(err, "failed to unmarshal value of response to %s", path)
+		}
+	}
+	return nil
+}
+
+// unknownCommandError is returned for commands that the driver, typically an
+// older version, does not implement.
+type unknownCommandError struct {
+	path string
+}
+
+func (e *unknownCommandError) Error() string {
+	return fmt.Sprintf("driver does not support %s", e.path)
+}
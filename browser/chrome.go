@@ -3,82 +3,273 @@ package browser
 import (
 	"context"
 	"fmt"
+	"net"
 	"path/filepath"
-	"time"
+	"sort"
+	"strings"
 
 	"github.com/fedesog/webdriver"
+	"github.com/jordanpotter/site-analyzer/utils"
 	"github.com/pkg/errors"
 )
 
 const (
+	chromeName                = "chrome"
 	chromedriverLogName       = "chromedriver.log"
 	chromedriverOutputLogName = "chromedriver_output.log"
 )
 
-func NewChrome(ctx context.Context, chromeDriverPath string, width, height, displayNum int, logsDir string) (*Browser, error) {
+type chrome struct {
+	url string
+}
+
+func NewChrome(ctx context.Context, chromeDriverPath string, opts *Options) (*Browser, error) {
+	port, err := freePort()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to find a free port for chromedriver")
+	}
+
 	chromeDriver := webdriver.NewChromeDriver(chromeDriverPath)
-	chromeDriver.LogPath = filepath.Join(logsDir, chromedriverLogName)
-	chromeDriver.LogFile = filepath.Join(logsDir, chromedriverOutputLogName)
+	chromeDriver.Port = port
+	chromeDriver.LogPath = filepath.Join(opts.LogsDir, chromedriverLogName)
+	chromeDriver.LogFile = filepath.Join(opts.LogsDir, chromedriverOutputLogName)
 
-	if deadline, ok := ctx.Deadline(); ok {
-		if deadline.Before(time.Now()) {
-			return nil, errors.New("context deadline exceeded")
-		}
-		chromeDriver.StartTimeout = deadline.Sub(time.Now())
+	chromeDriver.StartTimeout, err = startTimeout(ctx, chromeDriver.StartTimeout)
+	if err != nil {
+		return nil, err
 	}
 
 	if err := chromeDriver.Start(); err != nil {
 		return nil, errors.Wrap(err, "failed to start chromedriver")
 	}
 
-	session, err := chromeDriver.NewSession(chromeDesiredCapabilities(displayNum), chromeRequiredCapabilities())
+	session, err := chromeDriver.NewSession(chromeDesiredCapabilities(opts), chromeRequiredCapabilities())
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create a new session")
 	}
 
-	if err = session.SetTimeoutsImplicitWait(implicitWaitTimeoutMs); err != nil {
-		return nil, errors.Wrap(err, "failed to set implicit wait timeout")
+	c := chrome{fmt.Sprintf("http://127.0.0.1:%d%s", chromeDriver.Port, chromeDriver.BaseUrl)}
+	return newBrowser(chromeDriver, session, c, opts)
+}
+
+func (chrome) name() string {
+	return chromeName
+}
+
+func (chrome) driverVersion(caps webdriver.Capabilities) string {
+	chromeCaps, ok := caps["chrome"].(map[string]interface{})
+	if !ok {
+		return ""
 	}
 
-	if err = session.SetTimeoutsAsyncScript(asyncScriptTimeoutMs); err != nil {
-		return nil, errors.Wrap(err, "failed to set async script timeout")
+	version, _ := chromeCaps["chromedriverVersion"].(string)
+	return version
+}
+
+func (chrome) supportsPerformanceLog() bool {
+	return true
+}
+
+func (chrome) supportsRemoteCookies() bool {
+	return true
+}
+
+func (c chrome) setNetworkConditions(session *webdriver.Session, nc *NetworkConditions) error {
+	params := map[string]interface{}{
+		"network_conditions": map[string]interface{}{
+			"offline":             false,
+			"latency":             nc.Latency.Seconds() * 1000,
+			"download_throughput": kbpsThroughput(nc.DownloadKbps),
+			"upload_throughput":   kbpsThroughput(nc.UploadKbps),
+		},
 	}
+	return c.command(session, "chromium/network_conditions", params)
+}
 
-	window, err := session.WindowHandle()
+func (c chrome) setCPUThrottlingRate(session *webdriver.Session, rate float64) error {
+	return c.devTools(session, "Emulation.setCPUThrottlingRate", map[string]interface{}{"rate": rate})
+}
+
+func (c chrome) setExtraHeaders(session *webdriver.Session, headers map[string]string) error {
+	if err := c.devTools(session, "Network.enable", map[string]interface{}{}); err != nil {
+		return errors.Wrap(err, "failed to enable network")
+	}
+	return c.devTools(session, "Network.setExtraHTTPHeaders", map[string]interface{}{"headers": headers})
+}
+
+func (c chrome) setBlockedURLs(session *webdriver.Session, patterns []string) error {
+	if err := c.devTools(session, "Network.enable", map[string]interface{}{}); err != nil {
+		return errors.Wrap(err, "failed to enable network")
+	}
+	return c.devTools(session, "Network.setBlockedURLs", map[string]interface{}{"urls": patterns})
+}
+
+// setRemoteCookie sets the cookie as if origin had set it, without a page on
+// the origin being loaded.
+func (c chrome) setRemoteCookie(session *webdriver.Session, origin string, cookie Cookie) error {
+	params := map[string]interface{}{
+		"name":     cookie.Name,
+		"value":    cookie.Value,
+		"url":      origin,
+		"secure":   cookie.Secure,
+		"httpOnly": cookie.HTTPOnly,
+	}
+	if cookie.Path != "" {
+		params["path"] = cookie.Path
+	}
+	if cookie.Domain != "" {
+		params["domain"] = cookie.Domain
+	}
+	if cookie.Expiry != 0 {
+		params["expires"] = cookie.Expiry
+	}
+	return c.devTools(session, "Network.setCookie", params)
+}
+
+// windowDecoration measures the window with the device metrics cleared,
+// because emulation reports the device's dimensions to the page instead.
+func (c chrome) windowDecoration(session *webdriver.Session, device *Device) (int, int, error) {
+	if device == nil {
+		return measureWindowDecoration(session)
+	}
+
+	if err := c.devTools(session, "Emulation.clearDeviceMetricsOverride", map[string]interface{}{}); err != nil {
+		return 0, 0, errors.Wrap(err, "failed to clear device metrics")
+	}
+
+	width, height, err := measureWindowDecoration(session)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to get window handle")
+		return 0, 0, err
 	}
 
-	if err := window.SetPosition(webdriver.Position{X: 0, Y: 0}); err != nil {
-		return nil, errors.Wrap(err, "failed to set window position")
+	params := map[string]interface{}{
+		"width":             device.Width,
+		"height":            device.Height,
+		"deviceScaleFactor": device.PixelRatio,
+		"mobile":            device.Mobile,
+	}
+	if err = c.devTools(session, "Emulation.setDeviceMetricsOverride", params); err != nil {
+		return 0, 0, errors.Wrap(err, "failed to restore device metrics")
 	}
+	return width, height, nil
+}
 
-	if err := window.SetSize(webdriver.Size{Width: width, Height: height}); err != nil {
-		return nil, errors.Wrap(err, "failed to set window size")
+// devTools executes a DevTools protocol command in the session. Older
+// chromedrivers do not implement the command, so they are reported by version
+// rather than with their own unknown command response.
+func (c chrome) devTools(session *webdriver.Session, method string, params interface{}) error {
+	err := c.command(session, "goog/cdp/execute", map[string]interface{}{"cmd": method, "params": params})
+	if _, ok := err.(*unknownCommandError); ok {
+		return errors.Errorf("chromedriver %s cannot execute DevTools commands such as %s, use a newer chromedriver",
+			c.driverVersion(session.Capabilities), method)
 	}
+	return err
+}
+
+// kbpsThroughput converts kilobits per second to the bytes per second Chrome
+// expects, where -1 disables throttling.
+func kbpsThroughput(kbps int) int {
+	if kbps <= 0 {
+		return -1
+	}
+	return kbps * 1000 / 8
+}
+
+func (c chrome) command(session *webdriver.Session, path string, params interface{}) error {
+	return postCommand(c.url, session, path, params)
+}
 
-	return &Browser{chromeDriver, session}, nil
+func (c chrome) query(session *webdriver.Session, path string, params, value interface{}) error {
+	return postQuery(c.url, session, path, params, value)
 }
 
-func chromeDesiredCapabilities(displayNum int) webdriver.Capabilities {
+// freePort asks the kernel for an unused port, so that several chromedriver
+// instances can run side by side.
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to listen")
+	}
+	defer utils.MustFunc(l.Close)
+
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+func chromeDesiredCapabilities(opts *Options) webdriver.Capabilities {
+	chromeOptions := map[string]interface{}{
+		"args": []string{
+			"no-sandbox",
+			"disable-infobars",
+			"start-maximized",
+			fmt.Sprintf("display=:%d", opts.DisplayNum),
+		},
+		"perfLoggingPrefs": map[string]interface{}{
+			"enableNetwork": true,
+			"enablePage":    true,
+		},
+	}
+
+	if opts.Proxy != "" {
+		args := chromeOptions["args"].([]string)
+		chromeOptions["args"] = append(args,
+			fmt.Sprintf("proxy-server=http://%s", opts.Proxy),
+			"proxy-bypass-list=<-loopback>",
+			"ignore-certificate-errors",
+		)
+	}
+
+	if len(opts.Hosts) > 0 {
+		args := chromeOptions["args"].([]string)
+		chromeOptions["args"] = append(args, fmt.Sprintf("host-resolver-rules=%s", chromeHostResolverRules(opts.Hosts)))
+	}
+
+	if opts.Device != nil {
+		chromeOptions["mobileEmulation"] = chromeMobileEmulation(opts.Device, opts.UserAgent)
+	} else if opts.UserAgent != "" {
+		args := chromeOptions["args"].([]string)
+		chromeOptions["args"] = append(args, fmt.Sprintf("user-agent=%s", opts.UserAgent))
+	}
+
 	return webdriver.Capabilities{
 		"pageLoadStrategy": "none",
 		"loggingPrefs": map[string]interface{}{
 			consoleLogName:     webdriver.LogAll,
 			performanceLogName: webdriver.LogAll,
 		},
-		"chromeOptions": map[string]interface{}{
-			"args": []string{
-				"no-sandbox",
-				"disable-infobars",
-				"start-maximized",
-				fmt.Sprintf("display=:%d", displayNum),
-			},
-			"perfLoggingPrefs": map[string]interface{}{
-				"enableNetwork": true,
-				"enablePage":    true,
-			},
+		"chromeOptions": chromeOptions,
+	}
+}
+
+// chromeHostResolverRules maps each host to its IP address, ordered by host
+// so that the rules are the same from run to run.
+func chromeHostResolverRules(hosts map[string]string) string {
+	names := make([]string, 0, len(hosts))
+	for host := range hosts {
+		names = append(names, host)
+	}
+	sort.Strings(names)
+
+	rules := make([]string, 0, len(names))
+	for _, host := range names {
+		rules = append(rules, fmt.Sprintf("MAP %s %s", host, hosts[host]))
+	}
+	return strings.Join(rules, ", ")
+}
+
+func chromeMobileEmulation(device *Device, userAgent string) map[string]interface{} {
+	if userAgent == "" {
+		userAgent = device.UserAgent
+	}
+
+	return map[string]interface{}{
+		"deviceMetrics": map[string]interface{}{
+			"width":      device.Width,
+			"height":     device.Height,
+			"pixelRatio": device.PixelRatio,
+			"touch":      device.Touch,
+			"mobile":     device.Mobile,
 		},
+		"userAgent": userAgent,
 	}
 }
 
@@ -5,10 +5,11 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/fedesog/webdriver"
 	"github.com/jordanpotter/site-analyzer/utils"
 	"github.com/pkg/errors"
 )
@@ -16,37 +17,192 @@ import (
 const (
 	consoleLogName     = "browser"
 	consoleLogFilename = "console.log"
+	consoleErrorLevel  = "SEVERE"
+	unknownSource      = "unknown"
+	stackFramePrefix   = "at "
 )
 
+// Kinds of console log entries.
+const (
+	ConsoleException   = "exception"
+	ConsoleNetwork     = "network"
+	ConsoleDeprecation = "deprecation"
+	ConsoleViolation   = "violation"
+	ConsoleAPI         = "console"
+	ConsoleOther       = "other"
+)
+
+// consoleSourceKinds are the kinds of the sources chromedriver names for
+// console log entries. Entries from other sources are of another kind.
+var consoleSourceKinds = map[string]string{
+	"javascript":  ConsoleException,
+	"network":     ConsoleNetwork,
+	"deprecation": ConsoleDeprecation,
+	"violation":   ConsoleViolation,
+	"console-api": ConsoleAPI,
+}
+
+// consoleMessageRegexp matches Chrome's console messages, which start with
+// the source url and either a line and column, a line, or a dash. The text
+// may span several lines.
+var consoleMessageRegexp = regexp.MustCompile(`(?s)^(\S+) (?:(\d+)(?::(\d+))?|-) (.*)$`)
+
 type ConsoleLog struct {
 	Entries []ConsoleLogEntry
 }
 
+// ConsoleLogEntry is a message logged by the page. Offset is its time
+// relative to navigation start, and OffsetKnown is whether the browser
+// reported navigation start at all. Source, Line, Column and Text are parsed from
+// Message when it is in Chrome's format, and are otherwise empty apart from
+// Text, which is then the whole message. The Stack of an exception holds its
+// frames, such as "render (https://example.com/app.js:12:34)", innermost
+// first.
 type ConsoleLogEntry struct {
-	Level   string
-	Message string
-	Time    time.Time
+	Level       string
+	Message     string
+	Time        time.Time
+	Offset      time.Duration
+	OffsetKnown bool
+	Kind        string
+	Source      string
+	Line        int
+	Column      int
+	Text        string
+	Stack       []string
+}
+
+// consoleLogRecord is a console log entry as the driver reports it.
+// Chromedriver also names the source of the entry, which the webdriver
+// package's log entries leave out.
+type consoleLogRecord struct {
+	Timestamp float64 `json:"timestamp"`
+	Level     string  `json:"level"`
+	Message   string  `json:"message"`
+	Source    string  `json:"source"`
 }
 
 func (b *Browser) consoleLog() (*ConsoleLog, error) {
-	logEntries, err := b.session.Log(consoleLogName)
+	var records []consoleLogRecord
+	err := b.engine.query(b.session, "log", map[string]interface{}{"type": consoleLogName}, &records)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to retrieve console log")
 	}
 
-	consoleLogEntries := make([]ConsoleLogEntry, 0, len(logEntries))
-	for _, logEntry := range logEntries {
-		consoleLogEntry := consoleLogEntry(logEntry)
+	consoleLogEntries := make([]ConsoleLogEntry, 0, len(records))
+	for _, record := range records {
+		consoleLogEntry := consoleLogEntry(record)
 		consoleLogEntries = append(consoleLogEntries, consoleLogEntry)
 	}
 	return &ConsoleLog{consoleLogEntries}, nil
 }
 
-func consoleLogEntry(logEntry webdriver.LogEntry) ConsoleLogEntry {
-	return ConsoleLogEntry{
-		Level:   logEntry.Level,
-		Message: logEntry.Message,
-		Time:    time.Unix(int64(logEntry.TimeStamp/1000), 0),
+func consoleLogEntry(record consoleLogRecord) ConsoleLogEntry {
+	entry := ConsoleLogEntry{
+		Level:   record.Level,
+		Message: record.Message,
+		Time:    msTime(int64(record.Timestamp)),
+		Text:    record.Message,
+	}
+
+	if matches := consoleMessageRegexp.FindStringSubmatch(record.Message); matches != nil {
+		entry.Source = matches[1]
+		entry.Line, _ = strconv.Atoi(matches[2])
+		entry.Column, _ = strconv.Atoi(matches[3])
+		entry.Text = matches[4]
+	}
+
+	entry.Kind = consoleKind(record.Source, entry.Text)
+	switch entry.Kind {
+	case ConsoleAPI:
+		if text, err := strconv.Unquote(entry.Text); err == nil {
+			entry.Text = text
+		}
+	case ConsoleException:
+		entry.Text, entry.Stack = splitStack(entry.Text)
+	}
+	return entry
+}
+
+// splitStack separates the frames Chrome appends to an exception, each on
+// its own line starting with "at", from the exception's message.
+func splitStack(text string) (string, []string) {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if !strings.HasPrefix(strings.TrimSpace(line), stackFramePrefix) {
+			continue
+		}
+
+		stack := make([]string, 0, len(lines)-i)
+		for _, frame := range lines[i:] {
+			stack = append(stack, strings.TrimPrefix(strings.TrimSpace(frame), stackFramePrefix))
+		}
+		return strings.Join(lines[:i], "\n"), stack
+	}
+	return text, nil
+}
+
+// consoleKind classifies a message by the source the driver names for it.
+// Drivers that name none leave it to be guessed from the prefixes Chrome gives
+// the text, where console calls are logged as they were passed, quoted.
+func consoleKind(source, text string) string {
+	if source != "" {
+		if kind, ok := consoleSourceKinds[source]; ok {
+			return kind
+		}
+		return ConsoleOther
+	}
+
+	switch {
+	case strings.HasPrefix(text, "Uncaught"):
+		return ConsoleException
+	case strings.HasPrefix(text, "Failed to load resource"), strings.Contains(text, "net::ERR_"):
+		return ConsoleNetwork
+	case strings.HasPrefix(text, "[Deprecation]"), strings.Contains(text, "is deprecated"):
+		return ConsoleDeprecation
+	case strings.HasPrefix(text, "[Violation]"):
+		return ConsoleViolation
+	case strings.HasPrefix(text, `"`):
+		return ConsoleAPI
+	default:
+		return ConsoleOther
+	}
+}
+
+// Errors returns the entries logged at the error level.
+func (cl *ConsoleLog) Errors() []ConsoleLogEntry {
+	var entries []ConsoleLogEntry
+	for _, entry := range cl.Entries {
+		if strings.ToUpper(entry.Level) == consoleErrorLevel {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// ErrorsBySource counts the error entries logged by each source url. Errors
+// without one are counted as unknown.
+func (cl *ConsoleLog) ErrorsBySource() map[string]int {
+	counts := make(map[string]int)
+	for _, entry := range cl.Errors() {
+		source := entry.Source
+		if source == "" {
+			source = unknownSource
+		}
+		counts[source]++
+	}
+	return counts
+}
+
+// setOffsets sets the offset of each entry from navigation start, which is
+// left unknown when the browser did not report it.
+func (cl *ConsoleLog) setOffsets(navigationStart time.Time) {
+	if navigationStart.IsZero() {
+		return
+	}
+	for i := range cl.Entries {
+		cl.Entries[i].Offset = cl.Entries[i].Time.Sub(navigationStart)
+		cl.Entries[i].OffsetKnown = true
 	}
 }
 
@@ -77,9 +233,10 @@ func (cl *ConsoleLog) doSave(dir string) (string, error) {
 	defer utils.MustFunc(f.Close)
 
 	for _, entry := range cl.Entries {
-		time := entry.Time.Format(time.RFC3339)
+		time := entry.Time.Format(logTimeFormat)
+		offset := formatOffset(entry.Offset, entry.OffsetKnown)
 		level := strings.ToUpper(entry.Level)
-		str := fmt.Sprintf("%s %-7s %s\n", time, level, entry.Message)
+		str := fmt.Sprintf("%s %9s %-7s %-11s %s\n", time, offset, level, entry.Kind, entry.Message)
 		_, err = f.WriteString(str)
 		if err != nil {
 			return "", errors.Wrapf(err, "failed to write string to file %s", path)
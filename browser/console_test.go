@@ -0,0 +1,194 @@
+package browser
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConsoleLogEntry(t *testing.T) {
+	tests := []struct {
+		name   string
+		record consoleLogRecord
+		want   ConsoleLogEntry
+	}{
+		{
+			name: "console call",
+			record: consoleLogRecord{
+				Level:   "INFO",
+				Source:  "console-api",
+				Message: `https://example.com/app.js 12:8 "rendered in 20ms"`,
+			},
+			want: ConsoleLogEntry{
+				Kind:   ConsoleAPI,
+				Source: "https://example.com/app.js",
+				Line:   12,
+				Column: 8,
+				Text:   "rendered in 20ms",
+			},
+		},
+		{
+			name: "console call with a newline",
+			record: consoleLogRecord{
+				Level:   "SEVERE",
+				Source:  "console-api",
+				Message: `https://example.com/app.js 3:9 "first line\nsecond line"`,
+			},
+			want: ConsoleLogEntry{
+				Kind:   ConsoleAPI,
+				Source: "https://example.com/app.js",
+				Line:   3,
+				Column: 9,
+				Text:   "first line\nsecond line",
+			},
+		},
+		{
+			name: "console call mentioning an exception",
+			record: consoleLogRecord{
+				Level:   "WARNING",
+				Source:  "console-api",
+				Message: `https://example.com/app.js 7:3 "Uncaught errors are reported"`,
+			},
+			want: ConsoleLogEntry{
+				Kind:   ConsoleAPI,
+				Source: "https://example.com/app.js",
+				Line:   7,
+				Column: 3,
+				Text:   "Uncaught errors are reported",
+			},
+		},
+		{
+			name: "exception with a stack",
+			record: consoleLogRecord{
+				Level:  "SEVERE",
+				Source: "javascript",
+				Message: "https://example.com/app.js 5:12 Uncaught TypeError: Cannot read property 'x' of undefined\n" +
+					"    at render (https://example.com/app.js:5:12)\n" +
+					"    at https://example.com/app.js:9:3",
+			},
+			want: ConsoleLogEntry{
+				Kind:   ConsoleException,
+				Source: "https://example.com/app.js",
+				Line:   5,
+				Column: 12,
+				Text:   "Uncaught TypeError: Cannot read property 'x' of undefined",
+				Stack:  []string{"render (https://example.com/app.js:5:12)", "https://example.com/app.js:9:3"},
+			},
+		},
+		{
+			name: "localized exception",
+			record: consoleLogRecord{
+				Level:   "SEVERE",
+				Source:  "javascript",
+				Message: "https://example.com/app.js 5 Nicht abgefangener Error: kaputt",
+			},
+			want: ConsoleLogEntry{
+				Kind:   ConsoleException,
+				Source: "https://example.com/app.js",
+				Line:   5,
+				Text:   "Nicht abgefangener Error: kaputt",
+			},
+		},
+		{
+			name: "failed resource",
+			record: consoleLogRecord{
+				Level:   "SEVERE",
+				Source:  "network",
+				Message: "https://example.com/missing.png - Failed to load resource: the server responded with a status of 404 (Not Found)",
+			},
+			want: ConsoleLogEntry{
+				Kind:   ConsoleNetwork,
+				Source: "https://example.com/missing.png",
+				Text:   "Failed to load resource: the server responded with a status of 404 (Not Found)",
+			},
+		},
+		{
+			name: "violation",
+			record: consoleLogRecord{
+				Level:   "WARNING",
+				Source:  "violation",
+				Message: "https://example.com/ - [Violation] 'setTimeout' handler took 62ms",
+			},
+			want: ConsoleLogEntry{
+				Kind:   ConsoleViolation,
+				Source: "https://example.com/",
+				Text:   "[Violation] 'setTimeout' handler took 62ms",
+			},
+		},
+		{
+			name: "unknown source",
+			record: consoleLogRecord{
+				Level:   "WARNING",
+				Source:  "intervention",
+				Message: "https://example.com/ - Slow network is detected.",
+			},
+			want: ConsoleLogEntry{
+				Kind:   ConsoleOther,
+				Source: "https://example.com/",
+				Text:   "Slow network is detected.",
+			},
+		},
+		{
+			name: "exception without a source",
+			record: consoleLogRecord{
+				Level:   "SEVERE",
+				Message: "https://example.com/app.js 1:1 Uncaught ReferenceError: x is not defined\n    at https://example.com/app.js:1:1",
+			},
+			want: ConsoleLogEntry{
+				Kind:   ConsoleException,
+				Source: "https://example.com/app.js",
+				Line:   1,
+				Column: 1,
+				Text:   "Uncaught ReferenceError: x is not defined",
+				Stack:  []string{"https://example.com/app.js:1:1"},
+			},
+		},
+		{
+			name: "message without a position",
+			record: consoleLogRecord{
+				Level:   "INFO",
+				Message: "plain message",
+			},
+			want: ConsoleLogEntry{
+				Kind: ConsoleOther,
+				Text: "plain message",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		entry := consoleLogEntry(test.record)
+		test.want.Level = test.record.Level
+		test.want.Message = test.record.Message
+		test.want.Time = entry.Time
+		if !reflect.DeepEqual(entry, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, entry, test.want)
+		}
+	}
+}
+
+func TestSplitStack(t *testing.T) {
+	tests := []struct {
+		text      string
+		wantText  string
+		wantStack []string
+	}{
+		{"Uncaught Error: boom", "Uncaught Error: boom", nil},
+		{
+			"Uncaught Error: boom\n    at f (https://example.com/a.js:1:2)",
+			"Uncaught Error: boom",
+			[]string{"f (https://example.com/a.js:1:2)"},
+		},
+		{
+			"Uncaught Error: first line\nsecond line\n    at f (https://example.com/a.js:1:2)\n    at g (https://example.com/a.js:3:4)",
+			"Uncaught Error: first line\nsecond line",
+			[]string{"f (https://example.com/a.js:1:2)", "g (https://example.com/a.js:3:4)"},
+		},
+	}
+
+	for _, test := range tests {
+		text, stack := splitStack(test.text)
+		if text != test.wantText || !reflect.DeepEqual(stack, test.wantStack) {
+			t.Errorf("splitStack(%q) = %q, %q, want %q, %q", test.text, text, stack, test.wantText, test.wantStack)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package browser
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// Device describes how a phone or tablet presents pages. Width and Height are
+// the viewport in CSS pixels.
+type Device struct {
+	Name       string
+	Width      int
+	Height     int
+	PixelRatio float64
+	UserAgent  string
+	Touch      bool
+	Mobile     bool
+}
+
+var devices = map[string]*Device{
+	"iphone-x": {
+		Name:       "iphone-x",
+		Width:      375,
+		Height:     812,
+		PixelRatio: 3,
+		UserAgent:  "Mozilla/5.0 (iPhone; CPU iPhone OS 11_0 like Mac OS X) AppleWebKit/604.1.38 (KHTML, like Gecko) Version/11.0 Mobile/15A372 Safari/604.1",
+		Touch:      true,
+		Mobile:     true,
+	},
+	"iphone-8": {
+		Name:       "iphone-8",
+		Width:      375,
+		Height:     667,
+		PixelRatio: 2,
+		UserAgent:  "Mozilla/5.0 (iPhone; CPU iPhone OS 11_0 like Mac OS X) AppleWebKit/604.1.38 (KHTML, like Gecko) Version/11.0 Mobile/15A372 Safari/604.1",
+		Touch:      true,
+		Mobile:     true,
+	},
+	"pixel-2": {
+		Name:       "pixel-2",
+		Width:      411,
+		Height:     731,
+		PixelRatio: 2.625,
+		UserAgent:  "Mozilla/5.0 (Linux; Android 8.0; Pixel 2 Build/OPD3.170816.012) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/67.0.3396.87 Mobile Safari/537.36",
+		Touch:      true,
+		Mobile:     true,
+	},
+	"galaxy-s5": {
+		Name:       "galaxy-s5",
+		Width:      360,
+		Height:     640,
+		PixelRatio: 3,
+		UserAgent:  "Mozilla/5.0 (Linux; Android 5.0; SM-G900P Build/LRX21T) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/67.0.3396.87 Mobile Safari/537.36",
+		Touch:      true,
+		Mobile:     true,
+	},
+	"ipad": {
+		Name:       "ipad",
+		Width:      768,
+		Height:     1024,
+		PixelRatio: 2,
+		UserAgent:  "Mozilla/5.0 (iPad; CPU OS 11_0 like Mac OS X) AppleWebKit/604.1.34 (KHTML, like Gecko) Version/11.0 Mobile/15A5341f Safari/604.1",
+		Touch:      true,
+		Mobile:     true,
+	},
+}
+
+func LookupDevice(name string) (*Device, error) {
+	device, ok := devices[name]
+	if !ok {
+		return nil, errors.Errorf("unknown device %q", name)
+	}
+	return device, nil
+}
+
+// DeviceNames lists the known device profiles in alphabetical order.
+func DeviceNames() []string {
+	names := make([]string, 0, len(devices))
+	for name := range devices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,263 @@
+package browser
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DevToolsEvent is a performance log entry decoded into the DevTools event
+// it carries. Params holds a pointer to one of the typed event structs below,
+// or the raw json.RawMessage for methods that have no typed representation.
+// Timestamps within params are seconds on Chrome's monotonic clock, which
+// only NetworkRequestWillBeSent relates to wall time.
+type DevToolsEvent struct {
+	Method  string
+	Params  interface{}
+	Webview string
+	Time    time.Time
+}
+
+type NetworkRequest struct {
+	URL              string            `json:"url"`
+	Method           string            `json:"method"`
+	Headers          map[string]string `json:"headers"`
+	PostData         string            `json:"postData"`
+	HasPostData      bool              `json:"hasPostData"`
+	MixedContentType string            `json:"mixedContentType"`
+	InitialPriority  string            `json:"initialPriority"`
+	ReferrerPolicy   string            `json:"referrerPolicy"`
+}
+
+type NetworkResourceTiming struct {
+	RequestTime       float64 `json:"requestTime"`
+	ProxyStart        float64 `json:"proxyStart"`
+	ProxyEnd          float64 `json:"proxyEnd"`
+	DNSStart          float64 `json:"dnsStart"`
+	DNSEnd            float64 `json:"dnsEnd"`
+	ConnectStart      float64 `json:"connectStart"`
+	ConnectEnd        float64 `json:"connectEnd"`
+	SSLStart          float64 `json:"sslStart"`
+	SSLEnd            float64 `json:"sslEnd"`
+	WorkerStart       float64 `json:"workerStart"`
+	WorkerReady       float64 `json:"workerReady"`
+	SendStart         float64 `json:"sendStart"`
+	SendEnd           float64 `json:"sendEnd"`
+	PushStart         float64 `json:"pushStart"`
+	PushEnd           float64 `json:"pushEnd"`
+	ReceiveHeadersEnd float64 `json:"receiveHeadersEnd"`
+}
+
+type NetworkResponse struct {
+	URL               string                 `json:"url"`
+	Status            int                    `json:"status"`
+	StatusText        string                 `json:"statusText"`
+	Headers           map[string]string      `json:"headers"`
+	MimeType          string                 `json:"mimeType"`
+	RequestHeaders    map[string]string      `json:"requestHeaders"`
+	ConnectionReused  bool                   `json:"connectionReused"`
+	ConnectionID      float64                `json:"connectionId"`
+	RemoteIPAddress   string                 `json:"remoteIPAddress"`
+	RemotePort        int                    `json:"remotePort"`
+	FromDiskCache     bool                   `json:"fromDiskCache"`
+	FromServiceWorker bool                   `json:"fromServiceWorker"`
+	EncodedDataLength float64                `json:"encodedDataLength"`
+	Timing            *NetworkResourceTiming `json:"timing"`
+	Protocol          string                 `json:"protocol"`
+	SecurityState     string                 `json:"securityState"`
+	FromPrefetchCache bool                   `json:"fromPrefetchCache"`
+}
+
+type NetworkInitiator struct {
+	Type       string  `json:"type"`
+	URL        string  `json:"url"`
+	LineNumber float64 `json:"lineNumber"`
+}
+
+type NetworkRequestWillBeSent struct {
+	RequestID        string           `json:"requestId"`
+	LoaderID         string           `json:"loaderId"`
+	DocumentURL      string           `json:"documentURL"`
+	Request          NetworkRequest   `json:"request"`
+	Timestamp        float64          `json:"timestamp"`
+	WallTime         float64          `json:"wallTime"`
+	Initiator        NetworkInitiator `json:"initiator"`
+	RedirectResponse *NetworkResponse `json:"redirectResponse"`
+	Type             string           `json:"type"`
+	FrameID          string           `json:"frameId"`
+}
+
+type NetworkRequestServedFromCache struct {
+	RequestID string `json:"requestId"`
+}
+
+type NetworkResponseReceived struct {
+	RequestID string          `json:"requestId"`
+	LoaderID  string          `json:"loaderId"`
+	Timestamp float64         `json:"timestamp"`
+	Type      string          `json:"type"`
+	Response  NetworkResponse `json:"response"`
+	FrameID   string          `json:"frameId"`
+}
+
+type NetworkDataReceived struct {
+	RequestID         string  `json:"requestId"`
+	Timestamp         float64 `json:"timestamp"`
+	DataLength        int64   `json:"dataLength"`
+	EncodedDataLength int64   `json:"encodedDataLength"`
+}
+
+type NetworkLoadingFinished struct {
+	RequestID         string  `json:"requestId"`
+	Timestamp         float64 `json:"timestamp"`
+	EncodedDataLength float64 `json:"encodedDataLength"`
+}
+
+type NetworkLoadingFailed struct {
+	RequestID     string  `json:"requestId"`
+	Timestamp     float64 `json:"timestamp"`
+	Type          string  `json:"type"`
+	ErrorText     string  `json:"errorText"`
+	Canceled      bool    `json:"canceled"`
+	BlockedReason string  `json:"blockedReason"`
+}
+
+type NetworkResourceChangedPriority struct {
+	RequestID   string  `json:"requestId"`
+	NewPriority string  `json:"newPriority"`
+	Timestamp   float64 `json:"timestamp"`
+}
+
+type PageFrame struct {
+	ID             string `json:"id"`
+	ParentID       string `json:"parentId"`
+	LoaderID       string `json:"loaderId"`
+	Name           string `json:"name"`
+	URL            string `json:"url"`
+	SecurityOrigin string `json:"securityOrigin"`
+	MimeType       string `json:"mimeType"`
+}
+
+type PageFrameAttached struct {
+	FrameID       string `json:"frameId"`
+	ParentFrameID string `json:"parentFrameId"`
+}
+
+type PageFrameDetached struct {
+	FrameID string `json:"frameId"`
+}
+
+type PageFrameNavigated struct {
+	Frame PageFrame `json:"frame"`
+}
+
+type PageFrameStartedLoading struct {
+	FrameID string `json:"frameId"`
+}
+
+type PageFrameStoppedLoading struct {
+	FrameID string `json:"frameId"`
+}
+
+type PageFrameScheduledNavigation struct {
+	FrameID string  `json:"frameId"`
+	Delay   float64 `json:"delay"`
+	Reason  string  `json:"reason"`
+	URL     string  `json:"url"`
+}
+
+type PageFrameClearedScheduledNavigation struct {
+	FrameID string `json:"frameId"`
+}
+
+type PageDomContentEventFired struct {
+	Timestamp float64 `json:"timestamp"`
+}
+
+type PageLoadEventFired struct {
+	Timestamp float64 `json:"timestamp"`
+}
+
+var devToolsEventParams = map[string]func() interface{}{
+	"Network.requestWillBeSent":            func() interface{} { return &NetworkRequestWillBeSent{} },
+	"Network.requestServedFromCache":       func() interface{} { return &NetworkRequestServedFromCache{} },
+	"Network.responseReceived":             func() interface{} { return &NetworkResponseReceived{} },
+	"Network.dataReceived":                 func() interface{} { return &NetworkDataReceived{} },
+	"Network.loadingFinished":              func() interface{} { return &NetworkLoadingFinished{} },
+	"Network.loadingFailed":                func() interface{} { return &NetworkLoadingFailed{} },
+	"Network.resourceChangedPriority":      func() interface{} { return &NetworkResourceChangedPriority{} },
+	"Page.frameAttached":                   func() interface{} { return &PageFrameAttached{} },
+	"Page.frameDetached":                   func() interface{} { return &PageFrameDetached{} },
+	"Page.frameNavigated":                  func() interface{} { return &PageFrameNavigated{} },
+	"Page.frameStartedLoading":             func() interface{} { return &PageFrameStartedLoading{} },
+	"Page.frameStoppedLoading":             func() interface{} { return &PageFrameStoppedLoading{} },
+	"Page.frameScheduledNavigation":        func() interface{} { return &PageFrameScheduledNavigation{} },
+	"Page.frameClearedScheduledNavigation": func() interface{} { return &PageFrameClearedScheduledNavigation{} },
+	"Page.domContentEventFired":            func() interface{} { return &PageDomContentEventFired{} },
+	"Page.loadEventFired":                  func() interface{} { return &PageLoadEventFired{} },
+}
+
+type devToolsMessage struct {
+	Message struct {
+		Method string          `json:"method"`
+		Params json.RawMessage `json:"params"`
+	} `json:"message"`
+	Webview string `json:"webview"`
+}
+
+// paramsError is returned by Event when an entry is a well-formed DevTools
+// message whose params do not have the expected shape.
+type paramsError struct {
+	method string
+	err    error
+}
+
+func (e *paramsError) Error() string {
+	return fmt.Sprintf("failed to unmarshal %s params: %v", e.method, e.err)
+}
+
+// Events decodes the performance log entries. Entries whose params cannot be
+// decoded, such as an event whose format changed in a newer Chrome, are
+// skipped, so that one unexpected message does not lose the whole analysis.
+func (pl *PerformanceLog) Events() ([]*DevToolsEvent, error) {
+	events := make([]*DevToolsEvent, 0, len(pl.Entries))
+	for i, entry := range pl.Entries {
+		event, err := entry.Event()
+		if _, ok := err.(*paramsError); ok {
+			continue
+		} else if err != nil {
+			return nil, errors.Wrapf(err, "failed to decode performance log entry %d", i)
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+func (entry PerformanceLogEntry) Event() (*DevToolsEvent, error) {
+	var msg devToolsMessage
+	if err := json.Unmarshal([]byte(entry.Message), &msg); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal message")
+	}
+
+	event := &DevToolsEvent{
+		Method:  msg.Message.Method,
+		Params:  msg.Message.Params,
+		Webview: msg.Webview,
+		Time:    entry.Time,
+	}
+
+	newParams, ok := devToolsEventParams[event.Method]
+	if !ok {
+		return event, nil
+	}
+
+	params := newParams()
+	if err := json.Unmarshal(msg.Message.Params, params); err != nil {
+		return nil, &paramsError{event.Method, err}
+	}
+	event.Params = params
+
+	return event, nil
+}
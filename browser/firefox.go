@@ -0,0 +1,215 @@
+package browser
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/fedesog/webdriver"
+	"github.com/pkg/errors"
+)
+
+const (
+	firefoxName          = "firefox"
+	firefoxOutputLogName = "firefox_output.log"
+	firefoxDisplayEnv    = "DISPLAY"
+	firefoxUserAgentPref = "general.useragent.override"
+)
+
+// displayEnvMutex guards the DISPLAY environment variable, which Firefox
+// inherits from our process when it starts.
+var displayEnvMutex sync.Mutex
+
+type firefox struct {
+	url string
+}
+
+func NewFirefox(ctx context.Context, firefoxPath, xpiPath string, opts *Options) (*Browser, error) {
+	firefoxDriver := webdriver.NewFirefoxDriver(firefoxPath, xpiPath)
+	firefoxDriver.LogFile = filepath.Join(opts.LogsDir, firefoxOutputLogName)
+	firefoxDriver.SetLogPath(opts.LogsDir)
+	for name, value := range firefoxPrefs() {
+		firefoxDriver.Prefs[name] = value
+	}
+	if opts.Device != nil {
+		for name, value := range firefoxDevicePrefs(opts.Device) {
+			firefoxDriver.Prefs[name] = value
+		}
+	}
+	if opts.UserAgent != "" {
+		firefoxDriver.Prefs[firefoxUserAgentPref] = opts.UserAgent
+	}
+	if len(opts.Hosts) > 0 {
+		return nil, errors.New("firefox does not support mapping hosts")
+	}
+	if opts.Proxy != "" {
+		proxyPrefs, err := firefoxProxyPrefs(opts.Proxy)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to configure proxy")
+		}
+		for name, value := range proxyPrefs {
+			firefoxDriver.Prefs[name] = value
+		}
+	}
+
+	var err error
+	firefoxDriver.StartTimeout, err = startTimeout(ctx, firefoxDriver.StartTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = startOnDisplay(firefoxDriver, opts.DisplayNum); err != nil {
+		return nil, errors.Wrap(err, "failed to start firefox")
+	}
+
+	session, err := firefoxDriver.NewSession(firefoxDesiredCapabilities(), firefoxRequiredCapabilities())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create a new session")
+	}
+
+	f := firefox{fmt.Sprintf("http://127.0.0.1:%d/hub", firefoxDriver.Port)}
+	return newBrowser(firefoxDriver, session, f, opts)
+}
+
+func (firefox) name() string {
+	return firefoxName
+}
+
+// driverVersion is empty, since the Firefox webdriver extension does not
+// report its version.
+func (firefox) driverVersion(caps webdriver.Capabilities) string {
+	return ""
+}
+
+func (firefox) supportsPerformanceLog() bool {
+	return false
+}
+
+func (firefox) supportsRemoteCookies() bool {
+	return false
+}
+
+func (f firefox) command(session *webdriver.Session, path string, params interface{}) error {
+	return postCommand(f.url, session, path, params)
+}
+
+func (f firefox) query(session *webdriver.Session, path string, params, value interface{}) error {
+	return postQuery(f.url, session, path, params, value)
+}
+
+func (firefox) setNetworkConditions(session *webdriver.Session, nc *NetworkConditions) error {
+	return errors.New("firefox does not support network throttling")
+}
+
+func (firefox) setCPUThrottlingRate(session *webdriver.Session, rate float64) error {
+	return errors.New("firefox does not support cpu throttling")
+}
+
+func (firefox) setExtraHeaders(session *webdriver.Session, headers map[string]string) error {
+	return errors.New("firefox does not support extra headers")
+}
+
+func (firefox) setBlockedURLs(session *webdriver.Session, patterns []string) error {
+	return errors.New("firefox does not support blocking urls")
+}
+
+func (firefox) setRemoteCookie(session *webdriver.Session, origin string, cookie Cookie) error {
+	return errors.New("firefox does not support setting cookies without loading their origin")
+}
+
+// windowDecoration is measured directly, since Firefox does not emulate the
+// device's dimensions.
+func (firefox) windowDecoration(session *webdriver.Session, device *Device) (int, int, error) {
+	return measureWindowDecoration(session)
+}
+
+func startOnDisplay(firefoxDriver *webdriver.FirefoxDriver, displayNum int) error {
+	displayEnvMutex.Lock()
+	defer displayEnvMutex.Unlock()
+
+	prevDisplay, hadDisplay := os.LookupEnv(firefoxDisplayEnv)
+	if err := os.Setenv(firefoxDisplayEnv, fmt.Sprintf(":%d", displayNum)); err != nil {
+		return errors.Wrap(err, "failed to set display")
+	}
+
+	startErr := firefoxDriver.Start()
+
+	var err error
+	if hadDisplay {
+		err = os.Setenv(firefoxDisplayEnv, prevDisplay)
+	} else {
+		err = os.Unsetenv(firefoxDisplayEnv)
+	}
+
+	if startErr != nil {
+		return startErr
+	} else if err != nil {
+		return errors.Wrap(err, "failed to restore display")
+	}
+	return nil
+}
+
+// firefoxPrefs override the webdriver defaults so that Firefox behaves like
+// the Chrome backend: the disk cache is enabled for repeat views and page
+// loads do not block the session.
+func firefoxPrefs() map[string]interface{} {
+	return map[string]interface{}{
+		"browser.cache.disk.enable":   true,
+		"browser.cache.disk.capacity": 358400,
+		"webdriver.load.strategy":     "unstable",
+	}
+}
+
+// firefoxDevicePrefs approximate a device with preferences, since Firefox
+// lacks Chrome's mobile emulation. The pixel ratio is not emulated, because
+// scaling the content would shrink the viewport below the device width.
+func firefoxDevicePrefs(device *Device) map[string]interface{} {
+	touchEvents := 0
+	if device.Touch {
+		touchEvents = 1
+	}
+
+	return map[string]interface{}{
+		firefoxUserAgentPref:           device.UserAgent,
+		"dom.w3c_touch_events.enabled": touchEvents,
+	}
+}
+
+// firefoxProxyPrefs send HTTP and HTTPS through the proxy. Untrusted
+// certificates are already accepted by the webdriver defaults.
+func firefoxProxyPrefs(proxy string) (map[string]interface{}, error) {
+	host, portStr, err := net.SplitHostPort(proxy)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to split %q", proxy)
+	}
+
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse port %q", portStr)
+	}
+
+	return map[string]interface{}{
+		"network.proxy.type":          1,
+		"network.proxy.http":          host,
+		"network.proxy.http_port":     port,
+		"network.proxy.ssl":           host,
+		"network.proxy.ssl_port":      port,
+		"network.proxy.no_proxies_on": "",
+	}, nil
+}
+
+func firefoxDesiredCapabilities() webdriver.Capabilities {
+	return webdriver.Capabilities{
+		"loggingPrefs": map[string]interface{}{
+			consoleLogName: webdriver.LogAll,
+		},
+	}
+}
+
+func firefoxRequiredCapabilities() webdriver.Capabilities {
+	return webdriver.Capabilities{}
+}
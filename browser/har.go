@@ -0,0 +1,476 @@
+package browser
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jordanpotter/site-analyzer/utils"
+	"github.com/pkg/errors"
+)
+
+const (
+	harFilename    = "performance.har"
+	harVersion     = "1.2"
+	harCreatorName = "site-analyzer"
+	harTimeFormat  = "2006-01-02T15:04:05.000Z07:00"
+)
+
+type har struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Pages   []harPage  `json:"pages"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harPage struct {
+	StartedDateTime string         `json:"startedDateTime"`
+	ID              string         `json:"id"`
+	Title           string         `json:"title"`
+	PageTimings     harPageTimings `json:"pageTimings"`
+}
+
+type harPageTimings struct {
+	OnContentLoad float64 `json:"onContentLoad"`
+	OnLoad        float64 `json:"onLoad"`
+}
+
+type harEntry struct {
+	PageRef         string      `json:"pageref,omitempty"`
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	ServerIPAddress string      `json:"serverIPAddress,omitempty"`
+	Connection      string      `json:"connection,omitempty"`
+	Comment         string      `json:"comment,omitempty"`
+
+	// BlockedReason is a custom field, as HAR has no notion of blocking
+	BlockedReason string `json:"_blockedReason,omitempty"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harCookie    `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harCookie    `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harCookie struct {
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+	Path     string `json:"path,omitempty"`
+	Domain   string `json:"domain,omitempty"`
+	Expires  string `json:"expires,omitempty"`
+	HTTPOnly bool   `json:"httpOnly,omitempty"`
+	Secure   bool   `json:"secure,omitempty"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string         `json:"mimeType"`
+	Params   []harNameValue `json:"params"`
+	Text     string         `json:"text"`
+}
+
+type harContent struct {
+	Size        int64  `json:"size"`
+	Compression int64  `json:"compression,omitempty"`
+	MimeType    string `json:"mimeType"`
+}
+
+type harTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+// SaveHAR writes the network activity as a HAR archive, naming
+// creatorVersion as the version of the analyzer that produced it.
+func (pl *PerformanceLog) SaveHAR(ctx context.Context, dir, creatorVersion string) (string, error) {
+	var path string
+	var err error
+
+	c := make(chan bool, 1)
+	go func() {
+		path, err = pl.doSaveHAR(dir, creatorVersion)
+		c <- true
+	}()
+
+	select {
+	case <-c:
+		return path, err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func (pl *PerformanceLog) doSaveHAR(dir, creatorVersion string) (string, error) {
+	n, err := pl.network()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to reassemble network activity")
+	}
+
+	path := filepath.Join(dir, harFilename)
+	f, err := os.Create(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to create file %s", path)
+	}
+	defer utils.MustFunc(f.Close)
+
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	if err = encoder.Encode(n.har(creatorVersion)); err != nil {
+		return "", errors.Wrapf(err, "failed to write json to file %s", path)
+	}
+
+	return path, nil
+}
+
+func (n *network) har(creatorVersion string) *har {
+	log := harLog{
+		Version: harVersion,
+		Creator: harCreator{Name: harCreatorName, Version: creatorVersion},
+		Pages:   make([]harPage, 0, len(n.pages)),
+		Entries: make([]harEntry, 0, len(n.exchanges)),
+	}
+
+	for _, p := range n.pages {
+		log.Pages = append(log.Pages, p.har())
+	}
+
+	for _, e := range n.exchanges {
+		entry := e.har()
+		if p := n.pageFor(e); p != nil {
+			entry.PageRef = p.id
+		}
+		log.Entries = append(log.Entries, entry)
+	}
+
+	return &har{log}
+}
+
+func (p *page) har() harPage {
+	timings := harPageTimings{OnContentLoad: -1, OnLoad: -1}
+	if p.domContentLoaded > 0 {
+		timings.OnContentLoad = milliseconds(p.domContentLoaded - p.start)
+	}
+	if p.load > 0 {
+		timings.OnLoad = milliseconds(p.load - p.start)
+	}
+
+	return harPage{
+		StartedDateTime: harTime(p.wallTime),
+		ID:              p.id,
+		Title:           p.url,
+		PageTimings:     timings,
+	}
+}
+
+func (e *exchange) har() harEntry {
+	timings := e.harTimings()
+
+	entry := harEntry{
+		StartedDateTime: harTime(e.wallTime),
+		Time:            harTotal(timings),
+		Request:         e.harRequest(),
+		Response:        e.harResponse(),
+		Timings:         timings,
+	}
+
+	if e.response != nil {
+		entry.ServerIPAddress = strings.Trim(e.response.RemoteIPAddress, "[]")
+		if e.response.ConnectionID > 0 {
+			entry.Connection = strconv.FormatInt(int64(e.response.ConnectionID), 10)
+		}
+	}
+
+	if e.failed {
+		entry.Comment = e.errorText
+		entry.BlockedReason = e.blockedReason
+	}
+
+	return entry
+}
+
+func (e *exchange) harRequest() harRequest {
+	headers := e.request.Headers
+	if e.response != nil && len(e.response.RequestHeaders) > 0 {
+		headers = e.response.RequestHeaders
+	}
+
+	request := harRequest{
+		Method:      e.request.Method,
+		URL:         e.request.URL,
+		HTTPVersion: e.httpVersion(),
+		Cookies:     harRequestCookies(headers),
+		Headers:     harHeaders(headers),
+		QueryString: harQueryString(e.request.URL),
+		HeadersSize: -1,
+		BodySize:    int64(len(e.request.PostData)),
+	}
+
+	if e.request.PostData != "" {
+		request.PostData = &harPostData{
+			MimeType: headerValue(headers, "Content-Type"),
+			Params:   []harNameValue{},
+			Text:     e.request.PostData,
+		}
+	}
+
+	return request
+}
+
+func (e *exchange) harResponse() harResponse {
+	if e.response == nil {
+		return harResponse{
+			HTTPVersion: e.httpVersion(),
+			Cookies:     []harCookie{},
+			Headers:     []harNameValue{},
+			Content:     harContent{MimeType: "x-unknown"},
+			HeadersSize: -1,
+			BodySize:    -1,
+		}
+	}
+
+	bodySize := e.encodedDataLength
+	if e.response.FromDiskCache {
+		bodySize = 0
+	}
+
+	content := harContent{
+		Size:     e.dataLength,
+		MimeType: e.response.MimeType,
+	}
+	if compression := e.dataLength - e.encodedDataLength; bodySize > 0 && compression > 0 {
+		content.Compression = compression
+	}
+
+	return harResponse{
+		Status:      e.response.Status,
+		StatusText:  e.response.StatusText,
+		HTTPVersion: e.httpVersion(),
+		Cookies:     harResponseCookies(e.response.Headers),
+		Headers:     harHeaders(e.response.Headers),
+		Content:     content,
+		RedirectURL: headerValue(e.response.Headers, "Location"),
+		HeadersSize: -1,
+		BodySize:    bodySize,
+	}
+}
+
+// harTimings splits the exchange into the phases defined by the HAR spec.
+// Phases that did not occur are reported as -1.
+func (e *exchange) harTimings() harTimings {
+	timings := harTimings{DNS: -1, Connect: -1, SSL: -1}
+
+	end := e.end
+	if end == 0 {
+		end = math.Max(e.start, e.responseTime)
+	}
+
+	if e.response == nil || e.response.Timing == nil {
+		if e.responseTime > 0 {
+			timings.Wait = milliseconds(e.responseTime - e.start)
+			timings.Receive = milliseconds(end - e.responseTime)
+		} else {
+			timings.Wait = milliseconds(end - e.start)
+		}
+		return timings
+	}
+
+	t := e.response.Timing
+	queueing := milliseconds(t.RequestTime - e.start)
+
+	timings.Blocked = queueing + math.Max(0, firstNonNegative(t.DNSStart, t.ConnectStart, t.SendStart))
+	if t.DNSStart >= 0 && t.DNSEnd >= 0 {
+		timings.DNS = t.DNSEnd - t.DNSStart
+	}
+	if t.ConnectStart >= 0 && t.ConnectEnd >= 0 {
+		timings.Connect = t.ConnectEnd - t.ConnectStart
+	}
+	if t.SSLStart >= 0 {
+		timings.SSL = t.SSLEnd - t.SSLStart
+	}
+	timings.Send = t.SendEnd - t.SendStart
+	timings.Wait = t.ReceiveHeadersEnd - t.SendEnd
+	timings.Receive = math.Max(0, milliseconds(end-t.RequestTime-t.ReceiveHeadersEnd/1000))
+
+	return timings
+}
+
+func (e *exchange) httpVersion() string {
+	if e.response == nil {
+		return ""
+	}
+
+	switch protocol := strings.ToLower(e.response.Protocol); protocol {
+	case "h2":
+		return "HTTP/2.0"
+	case "http/1.0", "http/1.1":
+		return strings.ToUpper(protocol)
+	default:
+		return protocol
+	}
+}
+
+// harTotal sums the phases that make up the entry's total time. SSL is
+// already included in connect, so it is not counted again.
+func harTotal(t harTimings) float64 {
+	var total float64
+	for _, phase := range []float64{t.Blocked, t.DNS, t.Connect, t.Send, t.Wait, t.Receive} {
+		if phase > 0 {
+			total += phase
+		}
+	}
+	return total
+}
+
+func harHeaders(headers map[string]string) []harNameValue {
+	nameValues := make([]harNameValue, 0, len(headers))
+	for name, value := range headers {
+		// Repeated headers are joined with newlines
+		for _, v := range strings.Split(value, "\n") {
+			nameValues = append(nameValues, harNameValue{name, v})
+		}
+	}
+
+	sort.SliceStable(nameValues, func(i, j int) bool {
+		return nameValues[i].Name < nameValues[j].Name
+	})
+	return nameValues
+}
+
+func harQueryString(rawurl string) []harNameValue {
+	nameValues := []harNameValue{}
+
+	u, err := neturl.Parse(rawurl)
+	if err != nil {
+		return nameValues
+	}
+
+	for name, values := range u.Query() {
+		for _, value := range values {
+			nameValues = append(nameValues, harNameValue{name, value})
+		}
+	}
+
+	sort.SliceStable(nameValues, func(i, j int) bool {
+		return nameValues[i].Name < nameValues[j].Name
+	})
+	return nameValues
+}
+
+func harRequestCookies(headers map[string]string) []harCookie {
+	r := http.Request{Header: httpHeader(headers)}
+
+	cookies := []harCookie{}
+	for _, c := range r.Cookies() {
+		cookies = append(cookies, harCookie{Name: c.Name, Value: c.Value})
+	}
+	return cookies
+}
+
+func harResponseCookies(headers map[string]string) []harCookie {
+	r := http.Response{Header: httpHeader(headers)}
+
+	cookies := []harCookie{}
+	for _, c := range r.Cookies() {
+		cookie := harCookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Path:     c.Path,
+			Domain:   c.Domain,
+			HTTPOnly: c.HttpOnly,
+			Secure:   c.Secure,
+		}
+		if !c.Expires.IsZero() {
+			cookie.Expires = c.Expires.Format(harTimeFormat)
+		}
+		cookies = append(cookies, cookie)
+	}
+	return cookies
+}
+
+func httpHeader(headers map[string]string) http.Header {
+	h := make(http.Header, len(headers))
+	for name, value := range headers {
+		for _, v := range strings.Split(value, "\n") {
+			h.Add(name, v)
+		}
+	}
+	return h
+}
+
+func headerValue(headers map[string]string, name string) string {
+	for k, v := range headers {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return ""
+}
+
+func harTime(seconds float64) string {
+	sec, frac := math.Modf(seconds)
+	return time.Unix(int64(sec), int64(frac*1e9)).Format(harTimeFormat)
+}
+
+func firstNonNegative(values ...float64) float64 {
+	for _, v := range values {
+		if v >= 0 {
+			return v
+		}
+	}
+	return -1
+}
+
+// milliseconds converts seconds to milliseconds, rounded to the microsecond
+// to hide floating point noise from the monotonic clock arithmetic.
+func milliseconds(seconds float64) float64 {
+	return math.Floor(seconds*1e6+0.5) / 1e3
+}
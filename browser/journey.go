@@ -0,0 +1,225 @@
+package browser
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/fedesog/webdriver"
+	"github.com/pkg/errors"
+)
+
+const (
+	NavigateAction = "navigate"
+	ClickAction    = "click"
+	TypeAction     = "type"
+	WaitAction     = "wait"
+	ScrollAction   = "scroll"
+	SleepAction    = "sleep"
+	AssertAction   = "assert"
+)
+
+const scrollScript = `
+var selector = arguments[0];
+if (selector) {
+	document.querySelector(selector).scrollIntoView();
+} else {
+	window.scrollTo(arguments[1], arguments[2]);
+}
+`
+
+// Journey is a scripted flow around the analyzed page. Before steps run ahead
+// of the measured page load, for example to log in, and After steps run once
+// the page has loaded, for example to search.
+type Journey struct {
+	Before []Step `json:"before"`
+	After  []Step `json:"after"`
+}
+
+// Step is a single action of a journey. Which fields are used depends on the
+// action:
+//
+//	navigate: URL, and LoadedSpec to wait for
+//	click:    Selector
+//	type:     Selector and Text
+//	wait:     LoadedSpec
+//	scroll:   Selector to scroll into view, or X and Y to scroll to
+//	sleep:    Duration, such as "500ms"
+//	assert:   Selector, and Text the element must contain
+type Step struct {
+	Action     string      `json:"action"`
+	URL        string      `json:"url,omitempty"`
+	Selector   string      `json:"selector,omitempty"`
+	Text       string      `json:"text,omitempty"`
+	LoadedSpec *LoadedSpec `json:"loadedSpec,omitempty"`
+	X          int         `json:"x,omitempty"`
+	Y          int         `json:"y,omitempty"`
+	Duration   string      `json:"duration,omitempty"`
+}
+
+func ParseJourney(data []byte) (*Journey, error) {
+	var journey Journey
+	if err := json.Unmarshal(data, &journey); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal json")
+	}
+
+	if err := journey.Validate(); err != nil {
+		return nil, err
+	}
+	return &journey, nil
+}
+
+// Validate checks that every step has the fields its action needs.
+func (j *Journey) Validate() error {
+	for _, steps := range [][]Step{j.Before, j.After} {
+		for i := range steps {
+			if err := steps[i].validate(); err != nil {
+				return errors.Wrapf(err, "invalid step %d", i+1)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Step) validate() error {
+	switch s.Action {
+	case NavigateAction:
+		if s.URL == "" {
+			return errors.New("missing url")
+		}
+	case ClickAction, TypeAction, AssertAction:
+		if s.Selector == "" {
+			return errors.New("missing selector")
+		}
+	case WaitAction:
+		if s.LoadedSpec == nil {
+			return errors.New("missing loaded spec")
+		}
+	case ScrollAction:
+	case SleepAction:
+		if _, err := time.ParseDuration(s.Duration); err != nil {
+			return errors.Wrapf(err, "failed to parse duration %q", s.Duration)
+		}
+	default:
+		return errors.Errorf("unexpected action %q", s.Action)
+	}
+	return nil
+}
+
+// String describes the step for logs.
+func (s *Step) String() string {
+	switch s.Action {
+	case NavigateAction:
+		return s.Action + " " + s.URL
+	case SleepAction:
+		return s.Action + " " + s.Duration
+	case ScrollAction:
+		if s.Selector == "" {
+			return s.Action
+		}
+	case WaitAction:
+		return s.Action
+	}
+	return s.Action + " " + s.Selector
+}
+
+// RunStep performs the step and returns how long it took.
+func (b *Browser) RunStep(ctx context.Context, step *Step) (time.Duration, error) {
+	var duration time.Duration
+	var err error
+
+	c := make(chan bool, 1)
+	go func() {
+		duration, err = b.doRunStep(step)
+		c <- true
+	}()
+
+	select {
+	case <-c:
+		return duration, err
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+func (b *Browser) doRunStep(step *Step) (time.Duration, error) {
+	start := time.Now()
+
+	switch step.Action {
+	case NavigateAction:
+		spec := step.LoadedSpec
+		if spec == nil {
+			spec = &LoadedSpec{}
+		}
+		if _, err := b.load(step.URL, spec); err != nil {
+			return 0, errors.Wrapf(err, "failed to load %q", step.URL)
+		}
+	case ClickAction:
+		element, err := b.session.FindElement(webdriver.CSS_Selector, step.Selector)
+		if err != nil {
+			return 0, errors.Wrapf(err, "failed to find %q", step.Selector)
+		}
+		if err = element.Click(); err != nil {
+			return 0, errors.Wrapf(err, "failed to click %q", step.Selector)
+		}
+	case TypeAction:
+		element, err := b.session.FindElement(webdriver.CSS_Selector, step.Selector)
+		if err != nil {
+			return 0, errors.Wrapf(err, "failed to find %q", step.Selector)
+		}
+		if err = element.SendKeys(step.Text); err != nil {
+			return 0, errors.Wrapf(err, "failed to type into %q", step.Selector)
+		}
+	case WaitAction:
+		if _, err := b.waitUntilLoaded(step.LoadedSpec); err != nil {
+			return 0, errors.Wrap(err, "failed to wait until loaded")
+		}
+	case ScrollAction:
+		args := []interface{}{step.Selector, step.X, step.Y}
+		if _, err := b.session.ExecuteScript(scrollScript, args); err != nil {
+			return 0, errors.Wrap(err, "failed to execute script")
+		}
+	case SleepAction:
+		sleep, err := time.ParseDuration(step.Duration)
+		if err != nil {
+			return 0, errors.Wrapf(err, "failed to parse duration %q", step.Duration)
+		}
+		time.Sleep(sleep)
+	case AssertAction:
+		element, err := b.session.FindElement(webdriver.CSS_Selector, step.Selector)
+		if err != nil {
+			return 0, errors.Wrapf(err, "failed to find %q", step.Selector)
+		}
+		text, err := element.Text()
+		if err != nil {
+			return 0, errors.Wrapf(err, "failed to get text of %q", step.Selector)
+		}
+		if !strings.Contains(text, step.Text) {
+			return 0, errors.Errorf("expected %q to contain %q, found %q", step.Selector, step.Text, text)
+		}
+	default:
+		return 0, errors.Errorf("unexpected action %q", step.Action)
+	}
+
+	return time.Since(start), nil
+}
+
+// Screenshot is a PNG of the browser window.
+func (b *Browser) Screenshot(ctx context.Context) ([]byte, error) {
+	var data []byte
+	var err error
+
+	c := make(chan bool, 1)
+	go func() {
+		data, err = b.session.Screenshot()
+		c <- true
+	}()
+
+	select {
+	case <-c:
+		return data, errors.Wrap(err, "failed to take screenshot")
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
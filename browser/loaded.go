@@ -129,6 +129,12 @@ func (b *Browser) load(url string, spec *LoadedSpec) (time.Duration, error) {
 		return 0, errors.Wrap(err, "failed to set url")
 	}
 
+	return b.waitUntilLoaded(spec)
+}
+
+// waitUntilLoaded blocks until the current page satisfies the spec, and
+// returns when that happened relative to navigation start.
+func (b *Browser) waitUntilLoaded(spec *LoadedSpec) (time.Duration, error) {
 	script, err := spec.IsLoadedScript()
 	if err != nil {
 		return 0, errors.Wrap(err, "failed to retrieve script")
@@ -0,0 +1,27 @@
+package browser
+
+import (
+	"fmt"
+	"time"
+)
+
+// logTimeFormat keeps the milliseconds of log entry times, so that entries
+// can be lined up with network events and video frames.
+const logTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// unknownOffset is written in place of an offset from navigation start when
+// the browser did not report navigation start.
+const unknownOffset = "-"
+
+// msTime converts milliseconds since the Unix epoch to a time.
+func msTime(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+// formatOffset formats an offset from navigation start in milliseconds.
+func formatOffset(d time.Duration, known bool) string {
+	if !known {
+		return unknownOffset
+	}
+	return fmt.Sprintf("%+dms", d/time.Millisecond)
+}
@@ -0,0 +1,120 @@
+package browser
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const pageMetricsObserveTime = 250 * time.Millisecond
+
+const pageMetricsScript = `
+var observeTimeMs = arguments[0];
+var cb = arguments[arguments.length - 1];
+
+var metrics = {
+	firstPaint: null,
+	firstContentfulPaint: null,
+	largestContentfulPaint: null,
+	cumulativeLayoutShift: 0,
+	domContentLoaded: null,
+	loadEvent: null,
+	navigationStart: null
+};
+
+function observe(type, callback) {
+	try {
+		var observer = new PerformanceObserver(function(list) {
+			list.getEntries().forEach(callback);
+		});
+		observer.observe({type: type, buffered: true});
+	} catch (e) {
+		// The browser does not support this entry type
+	}
+}
+
+observe('paint', function(entry) {
+	if (entry.name === 'first-paint') {
+		metrics.firstPaint = entry.startTime;
+	} else if (entry.name === 'first-contentful-paint') {
+		metrics.firstContentfulPaint = entry.startTime;
+	}
+});
+
+observe('largest-contentful-paint', function(entry) {
+	metrics.largestContentfulPaint = entry.renderTime || entry.loadTime || entry.startTime;
+});
+
+observe('layout-shift', function(entry) {
+	if (!entry.hadRecentInput) {
+		metrics.cumulativeLayoutShift += entry.value;
+	}
+});
+
+function sinceNavigationStart(timestamp) {
+	return timestamp > 0 ? timestamp - window.performance.timing.navigationStart : null;
+}
+
+function done() {
+	var timing = window.performance.timing;
+	metrics.domContentLoaded = sinceNavigationStart(timing.domContentLoadedEventStart);
+	metrics.loadEvent = sinceNavigationStart(timing.loadEventStart);
+	metrics.navigationStart = timing.navigationStart;
+	cb(metrics);
+}
+
+// Buffered entries are delivered asynchronously
+setTimeout(done, observeTimeMs);
+`
+
+// PageMetrics are the paint and Web Vitals timings reported by the page,
+// relative to navigation start. Metrics the browser did not report are zero.
+// NavigationStart is when the navigation began by the browser's clock.
+type PageMetrics struct {
+	FirstPaint             time.Duration
+	FirstContentfulPaint   time.Duration
+	LargestContentfulPaint time.Duration
+	CumulativeLayoutShift  float64
+	DOMContentLoaded       time.Duration
+	LoadEvent              time.Duration
+	NavigationStart        time.Time
+}
+
+type pageMetricsResult struct {
+	FirstPaint             float64 `json:"firstPaint"`
+	FirstContentfulPaint   float64 `json:"firstContentfulPaint"`
+	LargestContentfulPaint float64 `json:"largestContentfulPaint"`
+	CumulativeLayoutShift  float64 `json:"cumulativeLayoutShift"`
+	DOMContentLoaded       float64 `json:"domContentLoaded"`
+	LoadEvent              float64 `json:"loadEvent"`
+	NavigationStart        float64 `json:"navigationStart"`
+}
+
+func (b *Browser) pageMetrics() (*PageMetrics, error) {
+	args := []interface{}{pageMetricsObserveTime.Seconds() * 1000}
+	data, err := b.session.ExecuteScriptAsync(pageMetricsScript, args)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to execute async script")
+	}
+
+	var result pageMetricsResult
+	if err = json.Unmarshal(data, &result); err != nil {
+		return nil, errors.Wrapf(err, "failed to unmarshal %q", data)
+	}
+
+	var navigationStart time.Time
+	if result.NavigationStart > 0 {
+		navigationStart = msTime(int64(result.NavigationStart))
+	}
+
+	return &PageMetrics{
+		FirstPaint:             msDuration(result.FirstPaint),
+		FirstContentfulPaint:   msDuration(result.FirstContentfulPaint),
+		LargestContentfulPaint: msDuration(result.LargestContentfulPaint),
+		CumulativeLayoutShift:  result.CumulativeLayoutShift,
+		DOMContentLoaded:       msDuration(result.DOMContentLoaded),
+		LoadEvent:              msDuration(result.LoadEvent),
+		NavigationStart:        navigationStart,
+	}, nil
+}
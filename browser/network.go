@@ -0,0 +1,157 @@
+package browser
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// exchange is a single request and its response, reassembled from the
+// Network.* events of the performance log. Timestamps are in seconds on
+// Chrome's monotonic clock; wallTime anchors them to the Unix epoch.
+type exchange struct {
+	id           string
+	frameID      string
+	resourceType string
+	request      NetworkRequest
+	response     *NetworkResponse
+	start        float64
+	wallTime     float64
+	responseTime float64
+	end          float64
+
+	dataLength        int64
+	encodedDataLength int64
+	transferSize      int64
+
+	failed        bool
+	errorText     string
+	canceled      bool
+	blockedReason string
+}
+
+// page is a top level navigation and the lifecycle events that followed it.
+type page struct {
+	id               string
+	frameID          string
+	url              string
+	start            float64
+	wallTime         float64
+	domContentLoaded float64
+	load             float64
+}
+
+type network struct {
+	exchanges []*exchange
+	pages     []*page
+}
+
+func (pl *PerformanceLog) network() (*network, error) {
+	events, err := pl.Events()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode performance log")
+	}
+
+	n := &network{}
+	pending := make(map[string]*exchange)
+
+	for _, event := range events {
+		switch params := event.Params.(type) {
+		case *NetworkRequestWillBeSent:
+			n.requestWillBeSent(pending, params)
+		case *NetworkResponseReceived:
+			if e, ok := pending[params.RequestID]; ok {
+				response := params.Response
+				e.response = &response
+				e.responseTime = params.Timestamp
+				e.resourceType = params.Type
+			}
+		case *NetworkDataReceived:
+			if e, ok := pending[params.RequestID]; ok {
+				e.dataLength += params.DataLength
+				e.encodedDataLength += params.EncodedDataLength
+			}
+		case *NetworkLoadingFinished:
+			if e, ok := pending[params.RequestID]; ok {
+				e.end = params.Timestamp
+				e.transferSize = int64(params.EncodedDataLength)
+				delete(pending, params.RequestID)
+			}
+		case *NetworkLoadingFailed:
+			if e, ok := pending[params.RequestID]; ok {
+				e.end = params.Timestamp
+				e.failed = true
+				e.errorText = params.ErrorText
+				e.canceled = params.Canceled
+				e.blockedReason = params.BlockedReason
+				delete(pending, params.RequestID)
+			}
+		case *PageDomContentEventFired:
+			if len(n.pages) > 0 {
+				n.pages[len(n.pages)-1].domContentLoaded = params.Timestamp
+			}
+		case *PageLoadEventFired:
+			if len(n.pages) > 0 {
+				n.pages[len(n.pages)-1].load = params.Timestamp
+			}
+		}
+	}
+
+	sort.SliceStable(n.exchanges, func(i, j int) bool {
+		return n.exchanges[i].start < n.exchanges[j].start
+	})
+
+	return n, nil
+}
+
+func (n *network) requestWillBeSent(pending map[string]*exchange, event *NetworkRequestWillBeSent) {
+	// Redirects reuse the request id, so the previous hop is complete
+	if prev, ok := pending[event.RequestID]; ok && event.RedirectResponse != nil {
+		prev.response = event.RedirectResponse
+		prev.responseTime = event.Timestamp
+		prev.end = event.Timestamp
+		delete(pending, event.RequestID)
+	}
+
+	e := &exchange{
+		id:           event.RequestID,
+		frameID:      event.FrameID,
+		resourceType: event.Type,
+		request:      event.Request,
+		start:        event.Timestamp,
+		wallTime:     event.WallTime,
+	}
+	pending[event.RequestID] = e
+	n.exchanges = append(n.exchanges, e)
+
+	if n.isNavigation(event) {
+		n.pages = append(n.pages, &page{
+			id:       event.LoaderID,
+			frameID:  event.FrameID,
+			url:      event.Request.URL,
+			start:    event.Timestamp,
+			wallTime: event.WallTime,
+		})
+	}
+}
+
+func (n *network) isNavigation(event *NetworkRequestWillBeSent) bool {
+	if event.Type != "Document" || event.RequestID != event.LoaderID || event.RedirectResponse != nil {
+		return false
+	}
+
+	// The first document request determines the main frame
+	return len(n.pages) == 0 || n.pages[0].frameID == event.FrameID
+}
+
+// pageFor returns the page that was active when the exchange started.
+func (n *network) pageFor(e *exchange) *page {
+	var p *page
+	for _, candidate := range n.pages {
+		if candidate.start > e.start {
+			break
+		}
+		p = candidate
+	}
+	return p
+}
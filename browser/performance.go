@@ -23,12 +23,18 @@ type PerformanceLog struct {
 }
 
 type PerformanceLogEntry struct {
-	Level   string
-	Message string
-	Time    time.Time
+	Level       string
+	Message     string
+	Time        time.Time
+	Offset      time.Duration
+	OffsetKnown bool
 }
 
 func (b *Browser) performanceLog() (*PerformanceLog, error) {
+	if !b.engine.supportsPerformanceLog() {
+		return &PerformanceLog{}, nil
+	}
+
 	logEntries, err := b.session.Log(performanceLogName)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to retrieve performance log")
@@ -46,7 +52,17 @@ func performanceLogEntry(logEntry webdriver.LogEntry) PerformanceLogEntry {
 	return PerformanceLogEntry{
 		Level:   logEntry.Level,
 		Message: logEntry.Message,
-		Time:    time.Unix(int64(logEntry.TimeStamp/1000), 0),
+		Time:    msTime(int64(logEntry.TimeStamp)),
+	}
+}
+
+func (pl *PerformanceLog) setOffsets(navigationStart time.Time) {
+	if navigationStart.IsZero() {
+		return
+	}
+	for i := range pl.Entries {
+		pl.Entries[i].Offset = pl.Entries[i].Time.Sub(navigationStart)
+		pl.Entries[i].OffsetKnown = true
 	}
 }
 
@@ -77,9 +93,10 @@ func (pl *PerformanceLog) doSave(dir string) (string, error) {
 	defer utils.MustFunc(f.Close)
 
 	for _, entry := range pl.Entries {
-		time := entry.Time.Format(time.RFC3339)
+		time := entry.Time.Format(logTimeFormat)
+		offset := formatOffset(entry.Offset, entry.OffsetKnown)
 		level := strings.ToUpper(entry.Level)
-		str := fmt.Sprintf("%s %-7s %s\n", time, level, entry.Message)
+		str := fmt.Sprintf("%s %9s %-7s %s\n", time, offset, level, entry.Message)
 		_, err = f.WriteString(str)
 		if err != nil {
 			return "", errors.Wrapf(err, "failed to write string to file %s", path)
@@ -0,0 +1,115 @@
+package browser
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// primePath is a cheap url on any origin to set the state on.
+const primePath = "/favicon.ico"
+
+// State is the cookies and localStorage entries to give the browser before
+// the measured page load, typically to be logged in.
+type State struct {
+	Cookies      []Cookie          `json:"cookies"`
+	LocalStorage map[string]string `json:"localStorage"`
+}
+
+// Cookie is set on the origin that is primed, unless it names a Domain.
+// Expiry is in seconds since the epoch, where zero is a session cookie.
+type Cookie struct {
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+	Path     string `json:"path,omitempty"`
+	Domain   string `json:"domain,omitempty"`
+	Secure   bool   `json:"secure,omitempty"`
+	HTTPOnly bool   `json:"httpOnly,omitempty"`
+	Expiry   int64  `json:"expiry,omitempty"`
+}
+
+func ParseState(data []byte) (*State, error) {
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal json")
+	}
+
+	for i, cookie := range state.Cookies {
+		if cookie.Name == "" {
+			return nil, errors.Errorf("missing name of cookie %d", i+1)
+		}
+	}
+
+	return &state, nil
+}
+
+// Prime gives origin the state before the measured page load, and reports
+// whether it had to load anything from the origin to do so. It should be
+// called before the video capture starts.
+//
+// Where the browser can set cookies without a page on their origin, a state
+// of only cookies is set without contacting the origin, so the first view
+// stays cold. Otherwise the origin's favicon is loaded, the state is set on
+// it, and the browser navigates away so that the priming is left out of the
+// logs of the next analysis. That resolves the origin's DNS, leaves
+// connections to it open and caches the favicon, so the first view is warmer
+// than a new visitor's, though not as warm as loading the origin's page, whose
+// scripts, styles and fonts would be cached too.
+func (b *Browser) Prime(ctx context.Context, origin string, state *State) (bool, error) {
+	var loaded bool
+	var err error
+
+	c := make(chan bool, 1)
+	go func() {
+		loaded, err = b.doPrime(origin, state)
+		c <- true
+	}()
+
+	select {
+	case <-c:
+		return loaded, err
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
+
+func (b *Browser) doPrime(origin string, state *State) (bool, error) {
+	if len(state.LocalStorage) == 0 && b.engine.supportsRemoteCookies() {
+		for _, cookie := range state.Cookies {
+			if err := b.engine.setRemoteCookie(b.session, origin, cookie); err != nil {
+				return false, errors.Wrapf(err, "failed to set cookie %q", cookie.Name)
+			}
+		}
+		return false, nil
+	}
+
+	// Cookies and localStorage can otherwise only be set for the current
+	// page's origin. Even when the favicon is missing, the error page is
+	// enough to be on the origin.
+	primeURL := origin + primePath
+	if _, err := b.load(primeURL, &LoadedSpec{}); err != nil {
+		return true, errors.Wrapf(err, "failed to load %q", primeURL)
+	}
+
+	for _, cookie := range state.Cookies {
+		if err := b.setCookie(cookie); err != nil {
+			return true, errors.Wrapf(err, "failed to set cookie %q", cookie.Name)
+		}
+	}
+
+	for key, value := range state.LocalStorage {
+		if err := b.session.LocalStorageSetKey(key, value); err != nil {
+			return true, errors.Wrapf(err, "failed to set localStorage key %q", key)
+		}
+	}
+
+	return true, b.doNavigateAway()
+}
+
+// setCookie posts the cookie itself, since the webdriver package's SetCookie
+// sends capitalized keys and an expiry of zero for session cookies, which
+// drivers reject or treat as already expired.
+func (b *Browser) setCookie(cookie Cookie) error {
+	return b.engine.command(b.session, "cookie", map[string]interface{}{"cookie": cookie})
+}
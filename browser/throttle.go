@@ -0,0 +1,49 @@
+package browser
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// NetworkConditions throttle the connection of the browser. A throughput of
+// zero leaves that direction unthrottled.
+type NetworkConditions struct {
+	Name         string
+	Latency      time.Duration
+	DownloadKbps int
+	UploadKbps   int
+}
+
+// networkPresets follow the connection profiles of WebPageTest.
+var networkPresets = map[string]*NetworkConditions{
+	"3g-slow": {Name: "3g-slow", Latency: 400 * time.Millisecond, DownloadKbps: 400, UploadKbps: 400},
+	"3g":      {Name: "3g", Latency: 300 * time.Millisecond, DownloadKbps: 1600, UploadKbps: 768},
+	"3g-fast": {Name: "3g-fast", Latency: 150 * time.Millisecond, DownloadKbps: 1600, UploadKbps: 768},
+	"4g":      {Name: "4g", Latency: 170 * time.Millisecond, DownloadKbps: 9000, UploadKbps: 9000},
+	"lte":     {Name: "lte", Latency: 70 * time.Millisecond, DownloadKbps: 12000, UploadKbps: 12000},
+	"dsl":     {Name: "dsl", Latency: 50 * time.Millisecond, DownloadKbps: 1500, UploadKbps: 384},
+	"cable":   {Name: "cable", Latency: 28 * time.Millisecond, DownloadKbps: 5000, UploadKbps: 1000},
+}
+
+func LookupNetworkConditions(name string) (*NetworkConditions, error) {
+	nc, ok := networkPresets[name]
+	if !ok {
+		return nil, errors.Errorf("unknown network conditions %q", name)
+	}
+
+	// Copy, so that callers may adjust the preset
+	copied := *nc
+	return &copied, nil
+}
+
+// NetworkPresetNames lists the network presets in alphabetical order.
+func NetworkPresetNames() []string {
+	names := make([]string, 0, len(networkPresets))
+	for name := range networkPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,116 @@
+package browser
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// WaterfallEntry breaks a single request down into the phases reported by
+// Chrome's resource timing. Start is relative to the start of the first
+// navigation, and phases that did not occur are zero. Blocked requests
+// matched one of the blocked url patterns and are also Failed. BlockedReason
+// is why Chrome stopped a request before it reached the network, which may
+// also be its content security policy, mixed content or the like.
+type WaterfallEntry struct {
+	URL           string
+	Method        string
+	ResourceType  string
+	Status        int
+	Start         time.Duration
+	Queueing      time.Duration
+	DNS           time.Duration
+	Connect       time.Duration
+	SSL           time.Duration
+	Send          time.Duration
+	Wait          time.Duration
+	Receive       time.Duration
+	Duration      time.Duration
+	TransferSize  int64
+	Failed        bool
+	Blocked       bool
+	BlockedReason string
+	Error         string
+}
+
+// End is when the request completed, relative to the start of the first
+// navigation.
+func (we *WaterfallEntry) End() time.Duration {
+	return we.Start + we.Duration
+}
+
+// blockedByPattern is the reason Chrome gives for requests stopped by
+// Network.setBlockedURLs.
+const blockedByPattern = "inspector"
+
+func (pl *PerformanceLog) Waterfall() ([]WaterfallEntry, error) {
+	n, err := pl.network()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to reassemble network activity")
+	}
+	return n.waterfall(), nil
+}
+
+func (n *network) waterfall() []WaterfallEntry {
+	if len(n.exchanges) == 0 {
+		return nil
+	}
+
+	origin := n.exchanges[0].start
+	if len(n.pages) > 0 {
+		origin = n.pages[0].start
+	}
+
+	entries := make([]WaterfallEntry, 0, len(n.exchanges))
+	for _, e := range n.exchanges {
+		entries = append(entries, e.waterfallEntry(origin))
+	}
+	return entries
+}
+
+func (e *exchange) waterfallEntry(origin float64) WaterfallEntry {
+	t := e.harTimings()
+
+	// HAR counts the TLS handshake as part of connect, the waterfall does not
+	connect := t.Connect
+	if t.SSL > 0 {
+		connect -= t.SSL
+	}
+
+	entry := WaterfallEntry{
+		URL:           e.request.URL,
+		Method:        e.request.Method,
+		ResourceType:  e.resourceType,
+		Start:         secondsDuration(e.start - origin),
+		Queueing:      msDuration(t.Blocked),
+		DNS:           msDuration(t.DNS),
+		Connect:       msDuration(connect),
+		SSL:           msDuration(t.SSL),
+		Send:          msDuration(t.Send),
+		Wait:          msDuration(t.Wait),
+		Receive:       msDuration(t.Receive),
+		Duration:      msDuration(harTotal(t)),
+		TransferSize:  e.transferSize,
+		Failed:        e.failed,
+		Blocked:       e.blockedReason == blockedByPattern,
+		BlockedReason: e.blockedReason,
+		Error:         e.errorText,
+	}
+
+	if e.response != nil {
+		entry.Status = e.response.Status
+	}
+
+	return entry
+}
+
+func secondsDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+func msDuration(ms float64) time.Duration {
+	if ms <= 0 {
+		return 0
+	}
+	return time.Duration(ms * float64(time.Millisecond))
+}
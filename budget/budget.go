@@ -0,0 +1,176 @@
+package budget
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/jordanpotter/site-analyzer/browser"
+	"github.com/jordanpotter/site-analyzer/video"
+)
+
+// Budget sets upper limits on the measurements of a run. Limits that are
+// omitted are not checked.
+type Budget struct {
+	MaxLoadTime          *Duration        `json:"maxLoadTime"`
+	MaxRequests          *int             `json:"maxRequests"`
+	MaxTransferBytes     *int64           `json:"maxTransferBytes"`
+	MaxConsoleErrors     *int             `json:"maxConsoleErrors"`
+	MaxSpeedIndex        *Duration        `json:"maxSpeedIndex"`
+	MaxResourceTypeBytes map[string]int64 `json:"maxResourceTypeBytes"`
+}
+
+// Duration is a time.Duration written as a string such as "2.5s" in json.
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return errors.Wrap(err, "failed to unmarshal duration string")
+	}
+
+	duration, err := time.ParseDuration(str)
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse duration %q", str)
+	}
+
+	d.Duration = duration
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// Measurements are the values of a run that budgets are checked against.
+// Requests, TransferBytes and ResourceTypeBytes are only meaningful when
+// NetworkMeasured is set.
+type Measurements struct {
+	LoadTime          time.Duration
+	Requests          int
+	TransferBytes     int64
+	ConsoleErrors     int
+	SpeedIndex        time.Duration
+	ResourceTypeBytes map[string]int64
+	NetworkMeasured   bool
+}
+
+// Violation is a single limit that a run exceeded. Durations are expressed in
+// milliseconds and sizes in bytes.
+type Violation struct {
+	Metric  string  `json:"metric"`
+	Limit   float64 `json:"limit"`
+	Actual  float64 `json:"actual"`
+	Message string  `json:"message"`
+}
+
+func ParseBudget(data []byte) (*Budget, error) {
+	var budget Budget
+	err := json.Unmarshal(data, &budget)
+	return &budget, errors.Wrap(err, "failed to unmarshal json")
+}
+
+func Measure(analysis *browser.Analysis, visualProgress *video.VisualProgress) *Measurements {
+	m := &Measurements{
+		LoadTime:          analysis.PageLoadTime,
+		ConsoleErrors:     len(analysis.ConsoleLog.Errors()),
+		ResourceTypeBytes: make(map[string]int64),
+		NetworkMeasured:   analysis.NetworkMeasured,
+	}
+
+	for _, entry := range analysis.Waterfall {
+		if entry.Blocked {
+			continue
+		}
+		m.Requests++
+		m.TransferBytes += entry.TransferSize
+		m.ResourceTypeBytes[strings.ToLower(entry.ResourceType)] += entry.TransferSize
+	}
+
+	if visualProgress != nil {
+		m.SpeedIndex = visualProgress.SpeedIndex
+	}
+
+	return m
+}
+
+// Check returns the limits the measurements exceeded. Limits on network
+// activity that was not measured are violated too, rather than passing
+// unchecked.
+func (b *Budget) Check(m *Measurements) []Violation {
+	var violations []Violation
+
+	if b.MaxLoadTime != nil && m.LoadTime > b.MaxLoadTime.Duration {
+		violations = append(violations, durationViolation("loadTime", b.MaxLoadTime.Duration, m.LoadTime))
+	}
+
+	if b.MaxRequests != nil && !m.NetworkMeasured {
+		violations = append(violations, unmeasuredViolation("requests", float64(*b.MaxRequests)))
+	} else if b.MaxRequests != nil && m.Requests > *b.MaxRequests {
+		violations = append(violations, countViolation("requests", int64(*b.MaxRequests), int64(m.Requests)))
+	}
+
+	if b.MaxTransferBytes != nil && !m.NetworkMeasured {
+		violations = append(violations, unmeasuredViolation("transferBytes", float64(*b.MaxTransferBytes)))
+	} else if b.MaxTransferBytes != nil && m.TransferBytes > *b.MaxTransferBytes {
+		violations = append(violations, countViolation("transferBytes", *b.MaxTransferBytes, m.TransferBytes))
+	}
+
+	if b.MaxConsoleErrors != nil && m.ConsoleErrors > *b.MaxConsoleErrors {
+		violations = append(violations, countViolation("consoleErrors", int64(*b.MaxConsoleErrors), int64(m.ConsoleErrors)))
+	}
+
+	if b.MaxSpeedIndex != nil && m.SpeedIndex > b.MaxSpeedIndex.Duration {
+		violations = append(violations, durationViolation("speedIndex", b.MaxSpeedIndex.Duration, m.SpeedIndex))
+	}
+
+	resourceTypes := make([]string, 0, len(b.MaxResourceTypeBytes))
+	for resourceType := range b.MaxResourceTypeBytes {
+		resourceTypes = append(resourceTypes, resourceType)
+	}
+	sort.Strings(resourceTypes)
+
+	for _, resourceType := range resourceTypes {
+		limit := b.MaxResourceTypeBytes[resourceType]
+		metric := fmt.Sprintf("resourceTypeBytes.%s", resourceType)
+		if !m.NetworkMeasured {
+			violations = append(violations, unmeasuredViolation(metric, float64(limit)))
+		} else if actual := m.ResourceTypeBytes[strings.ToLower(resourceType)]; actual > limit {
+			violations = append(violations, countViolation(metric, limit, actual))
+		}
+	}
+
+	return violations
+}
+
+func durationViolation(metric string, limit, actual time.Duration) Violation {
+	return Violation{
+		Metric:  metric,
+		Limit:   limit.Seconds() * 1000,
+		Actual:  actual.Seconds() * 1000,
+		Message: fmt.Sprintf("%s of %s exceeds budget of %s", metric, actual, limit),
+	}
+}
+
+func countViolation(metric string, limit, actual int64) Violation {
+	return Violation{
+		Metric:  metric,
+		Limit:   float64(limit),
+		Actual:  float64(actual),
+		Message: fmt.Sprintf("%s of %d exceeds budget of %d", metric, actual, limit),
+	}
+}
+
+func unmeasuredViolation(metric string, limit float64) Violation {
+	return Violation{
+		Metric:  metric,
+		Limit:   limit,
+		Message: fmt.Sprintf("%s was not measured by the browser, so its budget cannot be met", metric),
+	}
+}
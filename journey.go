@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/jordanpotter/site-analyzer/browser"
+	"github.com/jordanpotter/site-analyzer/video"
+)
+
+const (
+	journeyDirname = "journey"
+	beforePhase    = "before"
+	afterPhase     = "after"
+)
+
+// stepResult is a journey step that was performed. Before steps run ahead of
+// the capture, to keep them out of the video of the page load, so they are
+// pictured with a screenshot of the viewport. After steps are pictured with
+// the captured frame at their end, and their offset is from the capture's
+// first frame.
+type stepResult struct {
+	phase      string
+	step       browser.Step
+	offset     time.Duration
+	duration   time.Duration
+	screenshot []byte
+}
+
+// stepSummary is the machine readable result of a journey step.
+type stepSummary struct {
+	Phase      string  `json:"phase"`
+	Step       string  `json:"step"`
+	OffsetMs   float64 `json:"offsetMs,omitempty"`
+	DurationMs float64 `json:"durationMs"`
+	Thumbnail  string  `json:"thumbnail"`
+}
+
+func runBeforeSteps(ctx context.Context, j *job, b *browser.Browser) ([]stepResult, error) {
+	results := make([]stepResult, 0, len(j.Journey.Before))
+	for i := range j.Journey.Before {
+		step := j.Journey.Before[i]
+		j.log.Printf("Running step %q...", step.String())
+		duration, err := b.RunStep(ctx, &step)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to run step %q", step.String())
+		}
+
+		j.log.Printf("Step %q took %f seconds", step.String(), duration.Seconds())
+
+		screenshot, err := b.Screenshot(ctx)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to take screenshot after step %q", step.String())
+		}
+
+		results = append(results, stepResult{beforePhase, step, 0, duration, screenshot})
+	}
+	return results, nil
+}
+
+func runAfterSteps(ctx context.Context, j *job, b *browser.Browser, capture *video.Capture) ([]stepResult, error) {
+	results := make([]stepResult, 0, len(j.Journey.After))
+	for i := range j.Journey.After {
+		step := j.Journey.After[i]
+		j.log.Printf("Running step %q...", step.String())
+		offset := capture.Offset(time.Now())
+		duration, err := b.RunStep(ctx, &step)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to run step %q", step.String())
+		}
+
+		j.log.Printf("Step %q took %f seconds", step.String(), duration.Seconds())
+		results = append(results, stepResult{afterPhase, step, offset, duration, nil})
+	}
+	return results, nil
+}
+
+// saveJourney saves a thumbnail of every step into a journey directory and
+// adds the steps to the summary.
+func saveJourney(ctx context.Context, s *summary, steps []stepResult, capture *video.Capture, dir string) error {
+	journeyDir := filepath.Join(dir, journeyDirname)
+	if err := os.MkdirAll(journeyDir, 0755); err != nil {
+		return errors.Wrapf(err, "failed to create directory %s", journeyDir)
+	}
+
+	for i, result := range steps {
+		filename := fmt.Sprintf("%02d-%s-%s.png", i+1, result.phase, result.step.Action)
+		path := filepath.Join(journeyDir, filename)
+
+		if result.screenshot != nil {
+			if err := ioutil.WriteFile(path, result.screenshot, 0644); err != nil {
+				return errors.Wrapf(err, "failed to write file %s", path)
+			}
+		} else if err := capture.SaveFrame(ctx, result.offset+result.duration, path); err != nil {
+			return errors.Wrapf(err, "failed to save frame for step %q", result.step.String())
+		}
+
+		rel, err := filepath.Rel(s.dir, path)
+		if err != nil {
+			rel = path
+		}
+
+		s.Journey = append(s.Journey, stepSummary{
+			Phase:      result.phase,
+			Step:       result.step.String(),
+			OffsetMs:   milliseconds(result.offset),
+			DurationMs: milliseconds(result.duration),
+			Thumbnail:  rel,
+		})
+	}
+
+	s.addArtifact("journey", journeyDir)
+	return nil
+}
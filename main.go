@@ -1,134 +1,302 @@
 package main
 
 import (
-	"context"
 	"flag"
+	"fmt"
+	"io/ioutil"
 	"log"
+	"net"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
 
 	"github.com/jordanpotter/site-analyzer/browser"
-	"github.com/jordanpotter/site-analyzer/display"
-	"github.com/jordanpotter/site-analyzer/utils"
-	"github.com/jordanpotter/site-analyzer/video"
+	"github.com/jordanpotter/site-analyzer/budget"
+)
+
+// exitBudgetExceeded is the exit code when every analysis succeeded but at
+// least one exceeded its budget.
+const exitBudgetExceeded = 2
+
+const (
+	chromeBrowser  = "chrome"
+	firefoxBrowser = "firefox"
+)
+
+// customNetwork names network conditions given entirely by flags.
+const customNetwork = "custom"
+
+// Network conditions are imposed either by the browser's DevTools or by a
+// shaping proxy, which works with any browser.
+const (
+	devToolsThrottle = "devtools"
+	proxyThrottle    = "proxy"
+)
+
+// version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
+var (
+	url               string
+	urlsPath          string
+	concurrency       int
+	runs              int
+	repeatView        bool
+	width             int
+	height            int
+	deviceName        string
+	networkName       string
+	throttleWith      string
+	latencyFlag       string
+	downloadKbps      int
+	uploadKbps        int
+	cpuThrottlingRate float64
+	fps               int
+	dataDir           string
+	browserName       string
+	chromeDriverPath  string
+	firefoxPath       string
+	firefoxXPIPath    string
+	deadline          string
+	filmstrip         string
+	budgetPath        string
+	journeyPath       string
+	statePath         string
+	userAgent         string
+	headers           = make(headerFlags)
+	blockedURLs       stringsFlag
+	hosts             = make(hostsFlag)
+	recordPath        string
+	replayPath        string
 )
 
 var (
-	url              string
-	width            int
-	height           int
-	fps              int
-	dataDir          string
-	chromeDriverPath string
-	deadline         string
+	timeout           time.Duration
+	latency           time.Duration
+	filmstripInterval time.Duration
+	defaultBudget     *budget.Budget
+	defaultJourney    *browser.Journey
+	defaultState      *browser.State
 )
 
 func init() {
 	flag.StringVar(&url, "url", "", "url of the website")
+	flag.StringVar(&urlsPath, "urls", "", "file of urls to analyze, either one per line or as json lines")
+	flag.IntVar(&concurrency, "concurrency", 1, "number of urls to analyze concurrently")
+	flag.IntVar(&runs, "runs", 1, "number of times to analyze each url")
+	flag.BoolVar(&repeatView, "repeat-view", false, "also analyze a repeat view of the url with a warm cache")
 	flag.IntVar(&width, "width", 1600, "width of the captured video")
 	flag.IntVar(&height, "height", 1200, "height of the captured video")
+	flag.StringVar(&deviceName, "device", "", "device to emulate, one of "+strings.Join(browser.DeviceNames(), ", "))
+	flag.StringVar(&networkName, "network", "", "network conditions to emulate, either custom or one of "+strings.Join(browser.NetworkPresetNames(), ", "))
+	flag.StringVar(&throttleWith, "throttle-with", "", "throttle the network with devtools or a proxy, defaults to devtools for chrome and a proxy otherwise")
+	flag.StringVar(&latencyFlag, "latency", "0s", "round trip latency to add, overriding the network conditions or implying custom ones")
+	flag.IntVar(&downloadKbps, "download-kbps", 0, "download throughput in kbps, overriding the network conditions or implying custom ones")
+	flag.IntVar(&uploadKbps, "upload-kbps", 0, "upload throughput in kbps, overriding the network conditions or implying custom ones")
+	flag.Float64Var(&cpuThrottlingRate, "cpu-throttling", 1, "how many times slower to make the cpu")
 	flag.IntVar(&fps, "fps", 30, "fps of the captured video")
 	flag.StringVar(&dataDir, "data", ".", "directory to save output")
 	flag.StringVar(&deadline, "deadline", "60s", "cancel if have not completed within this duration")
+	flag.StringVar(&filmstrip, "filmstrip-interval", "100ms", "interval between filmstrip frames")
+	flag.StringVar(&budgetPath, "budget", "", "json file of performance budgets to check")
+	flag.StringVar(&journeyPath, "journey", "", "json file of steps to perform before and after the page load")
+	flag.StringVar(&statePath, "state", "", "json file of cookies and localStorage entries to set before the page load")
+	flag.StringVar(&userAgent, "user-agent", "", "user agent to send instead of the browser's")
+	flag.StringVar(&recordPath, "record", "", "archive every response to this file through a proxy")
+	flag.StringVar(&replayPath, "replay", "", "serve every response from this archive through a proxy, without the network")
+	flag.Var(hosts, "host", "connect to an ip in place of a host as \"host=ip\", may be repeated")
+	flag.Var(&blockedURLs, "block", "pattern of urls to block, where * is a wildcard, may be repeated")
+	flag.Var(headers, "header", "extra header to send with every request as \"Name: value\", may be repeated")
+	flag.StringVar(&browserName, "browser", chromeBrowser, "browser to analyze with, either chrome or firefox")
 	flag.StringVar(&chromeDriverPath, "chromedriver", "/usr/bin/chromedriver", "path to chromedriver binary")
+	flag.StringVar(&firefoxPath, "firefox", "/usr/bin/firefox", "path to firefox binary")
+	flag.StringVar(&firefoxXPIPath, "firefox-xpi", "", "path to the firefox webdriver extension")
 	flag.Parse()
 }
 
 func main() {
 	verifyFlags()
 
-	timeout, err := time.ParseDuration(deadline)
+	var err error
+	timeout, err = time.ParseDuration(deadline)
 	if err != nil {
 		log.Fatalf("Unexpected error while parsing deadline: %v", err)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
-
-	log.Printf("Analyzing %q...", url)
-	analysis, capture, err := analyzeAndCapture(ctx)
+	latency, err = time.ParseDuration(latencyFlag)
 	if err != nil {
-		log.Fatalf("Unexpected error: %v", err)
+		log.Fatalf("Unexpected error while parsing latency: %v", err)
+	} else if latency < 0 {
+		log.Fatalf("Invalid latency %s", latency)
 	}
 
-	log.Printf("Saving console logs...")
-	consoleLogPath, err := analysis.ConsoleLog.Save(ctx, dataDir)
-	if err != nil {
-		log.Fatalf("Unexpected error: %v", err)
+	// Latency or throughput without a preset describe the network entirely
+	if networkName == "" && (latency > 0 || downloadKbps > 0 || uploadKbps > 0) {
+		networkName = customNetwork
 	}
 
-	log.Printf("Saving performance logs...")
-	performanceLogPath, err := analysis.PerformanceLog.Save(ctx, dataDir)
+	filmstripInterval, err = time.ParseDuration(filmstrip)
 	if err != nil {
-		log.Fatalf("Unexpected error: %v", err)
+		log.Fatalf("Unexpected error while parsing filmstrip interval: %v", err)
 	}
 
-	log.Println("Saving video...")
-	videoPath, err := capture.SaveVideo(ctx, dataDir)
-	if err != nil {
-		log.Fatalf("Unexpected error while saving video: %v", err)
-	}
+	if budgetPath != "" {
+		data, err := ioutil.ReadFile(budgetPath)
+		if err != nil {
+			log.Fatalf("Unexpected error while reading budget: %v", err)
+		}
 
-	log.Println("Saving thumbnail...")
-	thumbnailPath, err := capture.SaveThumbnail(ctx, analysis.PageLoadTime, dataDir)
-	if err != nil {
-		log.Fatalf("Unexpected error while saving thumbnail: %v", err)
+		defaultBudget, err = budget.ParseBudget(data)
+		if err != nil {
+			log.Fatalf("Unexpected error while parsing budget: %v", err)
+		}
 	}
 
-	log.Printf("Page took %f seconds to load", analysis.PageLoadTime.Seconds())
-	log.Printf("Received %d console log entries", len(analysis.ConsoleLog.Entries))
-	log.Printf("Received %d performance log entries", len(analysis.PerformanceLog.Entries))
+	if journeyPath != "" {
+		data, err := ioutil.ReadFile(journeyPath)
+		if err != nil {
+			log.Fatalf("Unexpected error while reading journey: %v", err)
+		}
 
-	log.Printf("Console log saved to %s", consoleLogPath)
-	log.Printf("Performance log saved to %s", performanceLogPath)
-	log.Printf("Video saved to %s", videoPath)
-	log.Printf("Thumbnail saved to %s", thumbnailPath)
-}
-
-func analyzeAndCapture(ctx context.Context) (*browser.Analysis, *video.Capture, error) {
-	log.Println("Creating the display...")
-	d, err := display.New(ctx, width, height)
-	if err != nil {
-		return nil, nil, errors.Wrap(err, "failed to create display")
+		defaultJourney, err = browser.ParseJourney(data)
+		if err != nil {
+			log.Fatalf("Unexpected error while parsing journey: %v", err)
+		}
 	}
-	defer utils.MustFunc(d.Close)
 
-	log.Println("Opening Chrome...")
-	b, err := browser.NewChrome(ctx, chromeDriverPath, width, height, d.Num, dataDir)
-	if err != nil {
-		return nil, nil, errors.Wrap(err, "failed to create browser")
-	}
-	defer utils.MustFunc(b.Close)
+	if statePath != "" {
+		data, err := ioutil.ReadFile(statePath)
+		if err != nil {
+			log.Fatalf("Unexpected error while reading state: %v", err)
+		}
 
-	log.Println("Starting video capture...")
-	capture, err := video.StartCapture(ctx, d.Num, width, height, fps)
-	if err != nil {
-		return nil, nil, errors.Wrap(err, "failed to start video capture")
+		defaultState, err = browser.ParseState(data)
+		if err != nil {
+			log.Fatalf("Unexpected error while parsing state: %v", err)
+		}
 	}
-	defer utils.MustFunc(capture.Stop)
 
-	log.Println("Performing analysis...")
-	analysis, err := b.Analyze(ctx, url, &browser.LoadedSpec{}, 10*time.Second)
-	if err != nil {
-		return nil, nil, errors.Wrapf(err, "failed to analyze %q", url)
+	if urlsPath != "" {
+		jobs, err := readJobs(urlsPath)
+		if err != nil {
+			log.Fatalf("Unexpected error while reading urls: %v", err)
+		}
+
+		failed, overBudget := runBatch(jobs, concurrency)
+		if failed > 0 {
+			log.Fatalf("Failed to analyze %d of %d urls", failed, len(jobs))
+		} else if overBudget > 0 {
+			log.Printf("%d of %d urls exceeded their budget", overBudget, len(jobs))
+			os.Exit(exitBudgetExceeded)
+		}
+		return
 	}
 
-	return analysis, capture, nil
+	j := &job{URL: url, dir: dataDir, log: log.New(os.Stderr, "", log.LstdFlags)}
+	if err := run(j); isBudgetExceeded(err) {
+		log.Printf("%v", err)
+		os.Exit(exitBudgetExceeded)
+	} else if err != nil {
+		log.Fatalf("Unexpected error: %v", err)
+	}
 }
 
 func verifyFlags() {
-	if url == "" {
-		log.Fatalln("Must specify url")
+	if url == "" && urlsPath == "" {
+		log.Fatalln("Must specify url or urls file")
+	} else if url != "" && urlsPath != "" {
+		log.Fatalln("Cannot specify both url and urls file")
+	} else if concurrency <= 0 {
+		log.Fatalf("Invalid concurrency %d", concurrency)
+	} else if runs <= 0 {
+		log.Fatalf("Invalid number of runs %d", runs)
 	} else if width <= 0 {
 		log.Fatalf("Invalid video width %d", width)
 	} else if height <= 0 {
 		log.Fatalf("Invalid video height %d", height)
+	} else if _, err := browser.LookupDevice(deviceName); deviceName != "" && err != nil {
+		log.Fatalf("Invalid device %q", deviceName)
+	} else if _, err := browser.LookupNetworkConditions(networkName); networkName != "" && networkName != customNetwork && err != nil {
+		log.Fatalf("Invalid network conditions %q", networkName)
+	} else if throttleWith != "" && throttleWith != devToolsThrottle && throttleWith != proxyThrottle {
+		log.Fatalf("Invalid throttle %q", throttleWith)
+	} else if downloadKbps < 0 {
+		log.Fatalf("Invalid download throughput %d", downloadKbps)
+	} else if uploadKbps < 0 {
+		log.Fatalf("Invalid upload throughput %d", uploadKbps)
+	} else if cpuThrottlingRate < 1 {
+		log.Fatalf("Invalid cpu throttling rate %f", cpuThrottlingRate)
 	} else if fps <= 0 {
 		log.Fatalf("Invalid video fps %d", fps)
 	} else if dataDir == "" {
 		log.Fatalln("Must specify data directory")
+	} else if browserName != chromeBrowser && browserName != firefoxBrowser {
+		log.Fatalf("Invalid browser %q", browserName)
+	} else if recordPath != "" && urlsPath != "" {
+		log.Fatalln("Cannot record several urls to one archive, set \"record\" on each line instead")
+	} else if recordPath != "" && runs > 1 {
+		log.Fatalln("Cannot record more than one run")
+	} else if recordPath != "" && replayPath != "" {
+		log.Fatalln("Cannot specify both record and replay archives")
 	} else if chromeDriverPath == "" {
 		log.Fatalln("Must specify chromedriver path")
+	} else if browserName == firefoxBrowser && firefoxPath == "" {
+		log.Fatalln("Must specify firefox path")
+	} else if browserName == firefoxBrowser && firefoxXPIPath == "" {
+		log.Fatalln("Must specify firefox webdriver extension path")
+	}
+}
+
+// headerFlags collects repeated -header flags.
+type headerFlags map[string]string
+
+func (h headerFlags) String() string {
+	pairs := make([]string, 0, len(h))
+	for name, value := range h {
+		pairs = append(pairs, fmt.Sprintf("%s: %s", name, value))
+	}
+	return strings.Join(pairs, ", ")
+}
+
+func (h headerFlags) Set(header string) error {
+	parts := strings.SplitN(header, ":", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		return errors.Errorf("invalid header %q", header)
+	}
+	h[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	return nil
+}
+
+// stringsFlag collects a repeated flag.
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ", ")
+}
+
+func (s *stringsFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// hostsFlag collects repeated -host flags.
+type hostsFlag map[string]string
+
+func (h hostsFlag) String() string {
+	pairs := make([]string, 0, len(h))
+	for host, ip := range h {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", host, ip))
+	}
+	return strings.Join(pairs, ", ")
+}
+
+func (h hostsFlag) Set(mapping string) error {
+	parts := strings.SplitN(mapping, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || net.ParseIP(parts[1]) == nil {
+		return errors.Errorf("invalid host mapping %q", mapping)
 	}
+	h[parts[0]] = parts[1]
+	return nil
 }
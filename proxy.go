@@ -0,0 +1,92 @@
+package main
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/jordanpotter/site-analyzer/proxy"
+)
+
+// needsProxy is whether the job records, replays or shapes its traffic with
+// a proxy. Only Chrome can map hosts itself, so other browsers also need the
+// proxy for that.
+func needsProxy(j *job) bool {
+	if len(j.Hosts) > 0 && j.Browser != chromeBrowser {
+		return true
+	}
+	return j.Record != "" || j.Replay != "" || proxyShaping(j) != nil
+}
+
+// browserHosts is the job's host map when the browser resolves hosts rather
+// than the proxy.
+func browserHosts(j *job) map[string]string {
+	if j.Browser != chromeBrowser {
+		return nil
+	}
+	return j.Hosts
+}
+
+// proxyShaping is the job's network conditions when the proxy throttles the
+// network rather than the browser.
+func proxyShaping(j *job) *proxy.Shaping {
+	if j.network == nil || j.ThrottleWith != proxyThrottle {
+		return nil
+	}
+
+	return &proxy.Shaping{
+		Latency:      j.network.Latency,
+		DownloadKbps: j.network.DownloadKbps,
+		UploadKbps:   j.network.UploadKbps,
+	}
+}
+
+// openProxy starts a proxy that records the job's requests to an archive,
+// replays them from one, or only forwards them, shaping the traffic when
+// asked to.
+func openProxy(j *job) (*proxy.Proxy, *proxy.Archive, error) {
+	var mode string
+	var archive *proxy.Archive
+	var err error
+	if j.Record != "" {
+		mode, archive = proxy.RecordMode, proxy.NewArchive()
+	} else if j.Replay != "" {
+		mode = proxy.ReplayMode
+		if archive, err = proxy.LoadArchive(j.Replay); err != nil {
+			return nil, nil, errors.Wrap(err, "failed to load archive")
+		}
+	} else {
+		mode = proxy.ForwardMode
+	}
+
+	p, err := proxy.New(mode, archive, &proxy.Options{Shaping: proxyShaping(j), Hosts: j.Hosts})
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to start %s proxy", mode)
+	}
+	return p, archive, nil
+}
+
+// closeProxy stops the proxy, saving the archive when recording and noting
+// the requests that were missing from the archive when replaying.
+func closeProxy(j *job, s *summary, p *proxy.Proxy, archive *proxy.Archive) error {
+	if err := p.Close(); err != nil {
+		return errors.Wrap(err, "failed to close proxy")
+	}
+
+	if j.Record != "" {
+		j.log.Println("Saving archive...")
+		if err := archive.Save(j.Record); err != nil {
+			return errors.Wrap(err, "failed to save archive")
+		}
+		s.addArtifact("archive", j.Record)
+		j.log.Printf("Archive of %d responses saved to %s", len(archive.Entries), j.Record)
+		return nil
+	} else if j.Replay == "" {
+		return nil
+	}
+
+	misses := p.Misses()
+	s.ReplayMisses = len(misses)
+	for _, miss := range misses {
+		j.log.Printf("Not found in archive: %s", miss)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jordanpotter/site-analyzer/utils"
+	"github.com/pkg/errors"
+)
+
+// Archive is the responses recorded for a site. Requests are matched by
+// method and url, and a request made several times is answered with each
+// recorded response in turn.
+type Archive struct {
+	Entries []*Entry `json:"entries"`
+
+	mutex  sync.Mutex
+	served map[string]int
+}
+
+// Entry is a recorded response, along with how long the server took to start
+// responding and to finish.
+type Entry struct {
+	Method          string      `json:"method"`
+	URL             string      `json:"url"`
+	Status          int         `json:"status"`
+	Header          http.Header `json:"header"`
+	Body            []byte      `json:"body"`
+	TimeToFirstByte Duration    `json:"timeToFirstByte"`
+	Duration        Duration    `json:"duration"`
+}
+
+// Duration is a time.Duration stored in JSON as a string such as "120ms".
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return errors.Wrap(err, "failed to unmarshal duration")
+	}
+
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse duration %q", s)
+	}
+
+	*d = Duration(duration)
+	return nil
+}
+
+func NewArchive() *Archive {
+	return &Archive{served: make(map[string]int)}
+}
+
+func LoadArchive(path string) (*Archive, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read file %s", path)
+	}
+
+	a := NewArchive()
+	if err = json.Unmarshal(data, a); err != nil {
+		return nil, errors.Wrapf(err, "failed to unmarshal archive %s", path)
+	}
+	return a, nil
+}
+
+func (a *Archive) Save(path string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	f, err := os.Create(path)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create file %s", path)
+	}
+	defer utils.MustFunc(f.Close)
+
+	if err = json.NewEncoder(f).Encode(a); err != nil {
+		return errors.Wrapf(err, "failed to write json to file %s", path)
+	}
+	return nil
+}
+
+func (a *Archive) add(e *Entry) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.Entries = append(a.Entries, e)
+}
+
+// find returns the next recorded response for the request, repeating the
+// last one once they have all been served.
+func (a *Archive) find(method, url string) (*Entry, bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	var matches []*Entry
+	for _, e := range a.Entries {
+		if e.Method == method && e.URL == url {
+			matches = append(matches, e)
+		}
+	}
+
+	if len(matches) == 0 {
+		return nil, false
+	}
+
+	key := method + " " + url
+	i := a.served[key]
+	a.served[key]++
+	if i >= len(matches) {
+		i = len(matches) - 1
+	}
+	return matches[i], true
+}
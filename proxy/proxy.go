@@ -0,0 +1,372 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	ForwardMode = "forward"
+	RecordMode  = "record"
+	ReplayMode  = "replay"
+)
+
+// hopHeaders only apply to a single connection, so they are not forwarded.
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// Proxy is a forward proxy for the browser. In forward mode it fetches every
+// request from the origin server, in record mode it also archives the
+// response, and in replay mode it answers every request from the archive with
+// the recorded timing, without touching the network. HTTPS is intercepted
+// with certificates that the browser must be told to accept. With shaping,
+// the connections to the proxy are slowed down to match a slower network.
+type Proxy struct {
+	mode      string
+	archive   *Archive
+	ca        *certificateAuthority
+	transport *http.Transport
+	listener  net.Listener
+	server    *http.Server
+
+	mutex  sync.Mutex
+	conns  []net.Conn
+	misses []string
+}
+
+// Options adjust how the proxy reaches servers. Hosts maps hostnames to the
+// IP addresses to connect to in their place.
+type Options struct {
+	Shaping *Shaping
+	Hosts   map[string]string
+}
+
+func New(mode string, archive *Archive, opts *Options) (*Proxy, error) {
+	if mode != ForwardMode && mode != RecordMode && mode != ReplayMode {
+		return nil, errors.Errorf("unexpected mode %q", mode)
+	} else if mode != ForwardMode && archive == nil {
+		return nil, errors.Errorf("missing archive for %s mode", mode)
+	}
+
+	ca, err := newCertificateAuthority()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create certificate authority")
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to listen")
+	}
+
+	p := &Proxy{
+		mode:      mode,
+		archive:   archive,
+		ca:        ca,
+		transport: &http.Transport{DialContext: dialer(opts.Hosts)},
+		listener:  l,
+	}
+	p.server = &http.Server{Handler: p}
+	if opts.Shaping != nil {
+		go p.server.Serve(newShapedListener(l, opts.Shaping))
+	} else {
+		go p.server.Serve(l)
+	}
+
+	return p, nil
+}
+
+// Addr is the host and port the proxy listens on.
+func (p *Proxy) Addr() string {
+	return p.listener.Addr().String()
+}
+
+// Misses are the requests a replay could not find in the archive.
+func (p *Proxy) Misses() []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return append([]string(nil), p.misses...)
+}
+
+func (p *Proxy) Close() error {
+	err := p.server.Close()
+
+	p.mutex.Lock()
+	for _, conn := range p.conns {
+		conn.Close()
+	}
+	p.conns = nil
+	p.mutex.Unlock()
+
+	p.transport.CloseIdleConnections()
+	return errors.Wrap(err, "failed to close server")
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		p.serveConnect(w, r)
+	} else if r.URL.IsAbs() {
+		p.serve(w, r)
+	} else {
+		http.Error(w, "only absolute urls are proxied", http.StatusBadRequest)
+	}
+}
+
+// serveConnect terminates the TLS tunnel the browser asks for, and serves the
+// requests sent through it as if they had been sent to the proxy directly.
+func (p *Proxy) serveConnect(w http.ResponseWriter, r *http.Request) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "connection cannot be hijacked", http.StatusInternalServerError)
+		return
+	}
+
+	conn, _, err := hijacker.Hijack()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	p.track(conn)
+
+	if _, err = io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil {
+		conn.Close()
+		return
+	}
+
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+
+	tlsConn := tls.Server(conn, &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if hello.ServerName != "" {
+				return p.ca.certificate(hello.ServerName)
+			}
+			return p.ca.certificate(host)
+		},
+	})
+
+	authority := r.Host
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.URL.Scheme = "https"
+			r.URL.Host = r.Host
+			if r.URL.Host == "" {
+				r.URL.Host = authority
+			}
+			p.serve(w, r)
+		}),
+	}
+	server.Serve(newConnListener(tlsConn))
+}
+
+func (p *Proxy) serve(w http.ResponseWriter, r *http.Request) {
+	switch p.mode {
+	case ForwardMode:
+		p.forward(w, r)
+	case RecordMode:
+		p.record(w, r)
+	case ReplayMode:
+		p.replay(w, r)
+	}
+}
+
+// forward streams the response from the origin server.
+func (p *Proxy) forward(w http.ResponseWriter, r *http.Request) {
+	resp, err := p.roundTrip(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	copyHeader(w.Header(), cloneHeader(resp.Header))
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+func (p *Proxy) roundTrip(r *http.Request) (*http.Response, error) {
+	out, err := http.NewRequest(r.Method, r.URL.String(), r.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create request")
+	}
+	out.Header = cloneHeader(r.Header)
+	out.ContentLength = r.ContentLength
+
+	return p.transport.RoundTrip(out)
+}
+
+// record fetches the response in full before passing it on, so the recorded
+// timing is that of the origin server alone.
+func (p *Proxy) record(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	resp, err := p.roundTrip(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	timeToFirstByte := time.Since(start)
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	e := &Entry{
+		Method:          r.Method,
+		URL:             r.URL.String(),
+		Status:          resp.StatusCode,
+		Header:          cloneHeader(resp.Header),
+		Body:            body,
+		TimeToFirstByte: Duration(timeToFirstByte),
+		Duration:        Duration(time.Since(start)),
+	}
+	p.archive.add(e)
+
+	writeEntry(w, e)
+}
+
+// replay answers with the recorded response, waiting as long as the origin
+// server did before the first byte and before the last.
+func (p *Proxy) replay(w http.ResponseWriter, r *http.Request) {
+	url := r.URL.String()
+	e, ok := p.archive.find(r.Method, url)
+	if !ok {
+		p.mutex.Lock()
+		p.misses = append(p.misses, r.Method+" "+url)
+		p.mutex.Unlock()
+		http.Error(w, "not found in archive", http.StatusNotFound)
+		return
+	}
+
+	ctx := r.Context()
+	if !sleep(ctx, time.Duration(e.TimeToFirstByte)) {
+		return
+	}
+
+	copyHeader(w.Header(), e.Header)
+	w.Header().Set("Content-Length", strconv.Itoa(len(e.Body)))
+	w.WriteHeader(e.Status)
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+
+	if !sleep(ctx, time.Duration(e.Duration-e.TimeToFirstByte)) {
+		return
+	}
+	w.Write(e.Body)
+}
+
+func (p *Proxy) track(conn net.Conn) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.conns = append(p.conns, conn)
+}
+
+// dialer connects to the mapped IP address of hosts found in the map.
+func dialer(hosts map[string]string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	d := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to split %q", addr)
+		}
+
+		if ip, ok := hosts[host]; ok {
+			addr = net.JoinHostPort(ip, port)
+		}
+		return d.DialContext(ctx, network, addr)
+	}
+}
+
+func writeEntry(w http.ResponseWriter, e *Entry) {
+	copyHeader(w.Header(), e.Header)
+	w.Header().Set("Content-Length", strconv.Itoa(len(e.Body)))
+	w.WriteHeader(e.Status)
+	w.Write(e.Body)
+}
+
+func cloneHeader(h http.Header) http.Header {
+	clone := make(http.Header, len(h))
+	copyHeader(clone, h)
+	for _, name := range hopHeaders {
+		clone.Del(name)
+	}
+	return clone
+}
+
+func copyHeader(dst, src http.Header) {
+	for name, values := range src {
+		dst[name] = append([]string(nil), values...)
+	}
+}
+
+// sleep waits for d, returning false if the request was canceled first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// connListener hands a single connection to an http.Server.
+type connListener struct {
+	conn net.Conn
+	once sync.Once
+}
+
+func newConnListener(conn net.Conn) *connListener {
+	return &connListener{conn: conn}
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	var conn net.Conn
+	l.once.Do(func() {
+		conn = l.conn
+	})
+	if conn != nil {
+		return conn, nil
+	}
+	return nil, io.EOF
+}
+
+func (l *connListener) Close() error {
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
@@ -0,0 +1,417 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// packetSize is the most data delayed as a unit, the payload of an
+	// ethernet frame.
+	packetSize = 1460
+
+	// linkBuffer is how many packets may be in flight in each direction.
+	linkBuffer = 1024
+
+	// closeDrainLimit is how long Close waits, beyond the latency, for data
+	// already written to be delivered before dropping it.
+	closeDrainLimit = time.Second
+)
+
+// Shaping imposes the conditions of a slower network on the connections to
+// the proxy, independently of the browser. Latency is the round trip time,
+// which delays every packet by half in each direction and the start of every
+// connection by a whole round trip, in place of the TCP handshake. The
+// throughput in each direction is shared by all the connections, as they
+// would share a real link. A throughput of zero leaves that direction
+// unlimited.
+type Shaping struct {
+	Latency      time.Duration
+	DownloadKbps int
+	UploadKbps   int
+}
+
+// shapedListener shapes the connections it accepts over the same upload and
+// download bandwidth.
+type shapedListener struct {
+	net.Listener
+	shaping  *Shaping
+	upload   *bandwidth
+	download *bandwidth
+}
+
+func newShapedListener(l net.Listener, s *Shaping) *shapedListener {
+	return &shapedListener{l, s, &bandwidth{kbps: s.UploadKbps}, &bandwidth{kbps: s.DownloadKbps}}
+}
+
+func (l *shapedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return newShapedConn(conn, l.shaping.Latency, l.upload, l.download), nil
+}
+
+// shapedConn sends what is written to it over a shaped downlink, and reads
+// what arrives over a shaped uplink. Read deadlines apply to the shaped
+// data rather than the underlying connection, since http.Server relies on
+// them to interrupt reads.
+type shapedConn struct {
+	net.Conn
+	uplink   *link
+	downlink *link
+
+	incoming   chan []byte
+	pending    []byte
+	receiveErr error
+
+	deadlineMutex   sync.Mutex
+	readDeadline    time.Time
+	deadlineChanged chan struct{}
+
+	closeOnce    sync.Once
+	closeErr     error
+	closed       chan struct{}
+	downlinkDone chan struct{}
+}
+
+func newShapedConn(conn net.Conn, latency time.Duration, upload, download *bandwidth) *shapedConn {
+	c := &shapedConn{
+		Conn:            conn,
+		uplink:          newLink(latency/2, upload),
+		downlink:        newLink(latency/2, download),
+		incoming:        make(chan []byte),
+		deadlineChanged: make(chan struct{}),
+		closed:          make(chan struct{}),
+		downlinkDone:    make(chan struct{}),
+	}
+
+	// Nothing is sent until the handshake's round trip has completed
+	c.uplink.start = time.Now().Add(latency)
+
+	go c.receive()
+
+	go func() {
+		c.downlink.deliver(conn)
+		close(c.downlinkDone)
+	}()
+
+	return c
+}
+
+// receive passes what the peer sends through the uplink to Read.
+func (c *shapedConn) receive() {
+	delivered := make(chan error, 1)
+	go func() {
+		delivered <- c.uplink.deliver(incomingWriter{c})
+	}()
+
+	buf := make([]byte, 32*1024)
+	var readErr error
+	for readErr == nil {
+		var n int
+		n, readErr = c.Conn.Read(buf)
+		if n > 0 {
+			if err := c.uplink.send(buf[:n]); err != nil {
+				readErr = err
+			}
+		}
+	}
+
+	c.uplink.close()
+	if err := <-delivered; err != nil {
+		readErr = err
+	}
+
+	// Read only sees the error once the channel is closed
+	c.receiveErr = readErr
+	close(c.incoming)
+}
+
+func (c *shapedConn) Read(p []byte) (int, error) {
+	for len(c.pending) == 0 {
+		c.deadlineMutex.Lock()
+		deadline, changed := c.readDeadline, c.deadlineChanged
+		c.deadlineMutex.Unlock()
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			d := deadline.Sub(time.Now())
+			if d <= 0 {
+				return 0, timeoutError{}
+			}
+			timer = time.NewTimer(d)
+			timeout = timer.C
+		}
+
+		var err error
+		select {
+		case data, ok := <-c.incoming:
+			if ok {
+				c.pending = data
+			} else {
+				err = c.receiveErr
+			}
+		case <-timeout:
+			err = timeoutError{}
+		case <-changed:
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *shapedConn) Write(p []byte) (int, error) {
+	if err := c.downlink.send(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *shapedConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+func (c *shapedConn) SetReadDeadline(t time.Time) error {
+	c.deadlineMutex.Lock()
+	defer c.deadlineMutex.Unlock()
+
+	c.readDeadline = t
+	close(c.deadlineChanged)
+	c.deadlineChanged = make(chan struct{})
+	return nil
+}
+
+// Close interrupts any write in progress and delivers what has already been
+// written, as a real link would, while discarding what has yet to arrive. Delivery is given the latency and
+// closeDrainLimit, after which the rest is dropped, so that closing never
+// waits on a slow link for long.
+func (c *shapedConn) Close() error {
+	c.closeOnce.Do(func() {
+		// Whatever is still arriving will never be read
+		c.uplink.drop()
+		c.downlink.close()
+
+		t := time.NewTimer(c.downlink.delay + closeDrainLimit)
+		select {
+		case <-c.downlinkDone:
+		case <-t.C:
+			c.downlink.drop()
+			// Delivery may also be blocked on a peer that stopped reading
+			c.Conn.SetWriteDeadline(time.Now())
+			<-c.downlinkDone
+		}
+		t.Stop()
+
+		close(c.closed)
+		c.closeErr = c.Conn.Close()
+	})
+	return c.closeErr
+}
+
+// incomingWriter hands what the uplink delivers to Read.
+type incomingWriter struct {
+	c *shapedConn
+}
+
+func (w incomingWriter) Write(p []byte) (int, error) {
+	data := make([]byte, len(p))
+	copy(data, p)
+
+	select {
+	case w.c.incoming <- data:
+		return len(p), nil
+	case <-w.c.closed:
+		return 0, errors.New("connection closed")
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+type packet struct {
+	data      []byte
+	deliverAt time.Time
+}
+
+// bandwidth is the throughput of one direction, shared by every connection.
+// Packets are serialized one after another, whichever connection sends them.
+type bandwidth struct {
+	kbps int
+
+	mutex sync.Mutex
+	next  time.Time
+}
+
+// reserve schedules the transmission of n bytes, and returns when it ends.
+func (b *bandwidth) reserve(n int) time.Time {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := time.Now()
+	if b.next.Before(now) {
+		b.next = now
+	}
+	b.next = b.next.Add(transmitTime(n, b.kbps))
+	return b.next
+}
+
+// link is one direction of a connection. Packets are serialized over the
+// shared bandwidth, no earlier than start, and then delayed by the link's
+// one-way latency. Closing the link interrupts a send, and dropping it
+// discards the packets still to be delivered.
+type link struct {
+	delay     time.Duration
+	bandwidth *bandwidth
+	packets   chan packet
+	closing   chan struct{}
+	dropped   chan struct{}
+
+	// mutex is held for the whole of a send, which stops at closing
+	mutex     sync.Mutex
+	start     time.Time
+	closed    bool
+	closeOnce sync.Once
+	dropOnce  sync.Once
+
+	// errMutex is separate, as delivery fails while a send may be blocked
+	errMutex sync.Mutex
+	err      error
+}
+
+func newLink(delay time.Duration, bw *bandwidth) *link {
+	return &link{
+		delay:     delay,
+		bandwidth: bw,
+		packets:   make(chan packet, linkBuffer),
+		closing:   make(chan struct{}),
+		dropped:   make(chan struct{}),
+	}
+}
+
+// send blocks for as long as the data takes to serialize.
+func (l *link) send(data []byte) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for len(data) > 0 {
+		if l.closed {
+			return errors.New("link closed")
+		} else if err := l.failure(); err != nil {
+			return err
+		}
+
+		n := len(data)
+		if n > packetSize {
+			n = packetSize
+		}
+
+		// Waiting for the start first leaves the bandwidth to other links
+		if !sleepUntil(l.start, l.closing) || !sleepUntil(l.bandwidth.reserve(n), l.closing) {
+			return errors.New("link closed")
+		}
+
+		chunk := make([]byte, n)
+		copy(chunk, data)
+		l.packets <- packet{chunk, time.Now().Add(l.delay)}
+		data = data[n:]
+	}
+
+	return nil
+}
+
+// deliver writes every packet once its delay has passed, until the link is
+// closed. After a failed write, or once the link is dropped, the remaining
+// packets are discarded.
+func (l *link) deliver(w io.Writer) error {
+	var err error
+	for p := range l.packets {
+		if err != nil || !sleepUntil(p.deliverAt, l.dropped) {
+			continue
+		}
+
+		if _, err = w.Write(p.data); err != nil {
+			l.fail(err)
+		}
+	}
+	return err
+}
+
+func (l *link) fail(err error) {
+	l.errMutex.Lock()
+	defer l.errMutex.Unlock()
+	l.err = err
+}
+
+func (l *link) failure() error {
+	l.errMutex.Lock()
+	defer l.errMutex.Unlock()
+	return l.err
+}
+
+// close stops sending, waiting only for a send in progress to notice.
+func (l *link) close() {
+	l.closeOnce.Do(func() { close(l.closing) })
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if !l.closed {
+		l.closed = true
+		close(l.packets)
+	}
+}
+
+func (l *link) drop() {
+	l.dropOnce.Do(func() { close(l.dropped) })
+}
+
+// sleepUntil sleeps until t, and reports false if interrupted by done first.
+func sleepUntil(t time.Time, done <-chan struct{}) bool {
+	d := t.Sub(time.Now())
+	if d <= 0 {
+		select {
+		case <-done:
+			return false
+		default:
+			return true
+		}
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+func transmitTime(bytes, kbps int) time.Duration {
+	if kbps <= 0 {
+		return 0
+	}
+	return time.Duration(bytes) * 8 * time.Second / time.Duration(kbps*1000)
+}
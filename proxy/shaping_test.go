@@ -0,0 +1,207 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// bodyServer responds with as many bytes as the size query parameter asks for.
+func bodyServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		size, _ := strconv.Atoi(r.URL.Query().Get("size"))
+		w.Write(bytes.Repeat([]byte("x"), size))
+	}))
+}
+
+// shapedClient starts a forward proxy with the shaping and a client that
+// sends every request through it.
+func shapedClient(t *testing.T, shaping *Shaping) (*Proxy, *http.Client) {
+	p, err := New(ForwardMode, nil, &Options{Shaping: shaping})
+	if err != nil {
+		t.Fatalf("failed to start proxy: %v", err)
+	}
+
+	proxyURL, err := url.Parse("http://" + p.Addr())
+	if err != nil {
+		t.Fatalf("failed to parse proxy url: %v", err)
+	}
+
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	return p, client
+}
+
+// Shaping only ever delays data, so the tests check lower bounds closely.
+// Upper bounds are loose, since a loaded machine delays data further.
+const (
+	lowerTolerance = 0.9
+	upperTolerance = 3
+)
+
+// timedGet fetches size bytes from the server, and returns how long the first
+// byte of the body took to arrive and how long the rest of it took after that.
+// The pace of the rest is set by the throughput alone.
+func timedGet(client *http.Client, server *httptest.Server, size int) (time.Duration, time.Duration, error) {
+	start := time.Now()
+	resp, err := client.Get(server.URL + "/?size=" + strconv.Itoa(size))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+
+	first := make([]byte, 1)
+	if _, err = io.ReadFull(resp.Body, first); err != nil {
+		return 0, 0, err
+	}
+	firstByte := time.Since(start)
+
+	rest, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, 0, err
+	} else if len(rest)+1 != size {
+		return 0, 0, errors.Errorf("got %d bytes, want %d", len(rest)+1, size)
+	}
+	return firstByte, time.Since(start) - firstByte, nil
+}
+
+func within(d, want time.Duration) bool {
+	return float64(d) >= lowerTolerance*float64(want) && float64(d) <= upperTolerance*float64(want)
+}
+
+func TestShapingLatency(t *testing.T) {
+	server := bodyServer()
+	defer server.Close()
+
+	latency := 200 * time.Millisecond
+	p, client := shapedClient(t, &Shaping{Latency: latency})
+	defer p.Close()
+
+	firstByte, _, err := timedGet(client, server, 10)
+	if err != nil {
+		t.Fatalf("failed to get: %v", err)
+	}
+
+	// The handshake takes a round trip, and the request and response another
+	if firstByte < 2*latency {
+		t.Errorf("first byte took %s, want at least %s", firstByte, 2*latency)
+	}
+}
+
+func TestShapingThroughput(t *testing.T) {
+	server := bodyServer()
+	defer server.Close()
+
+	kbps, size := 800, 50000
+	p, client := shapedClient(t, &Shaping{DownloadKbps: kbps})
+	defer p.Close()
+
+	_, rest, err := timedGet(client, server, size)
+	if err != nil {
+		t.Fatalf("failed to get: %v", err)
+	}
+
+	// The first packet arrives with the first byte
+	want := transmitTime(size-packetSize, kbps)
+	if !within(rest, want) {
+		t.Errorf("%d bytes after the first packet took %s, want about %s", size-packetSize, rest, want)
+	}
+}
+
+func TestShapingSharesThroughput(t *testing.T) {
+	server := bodyServer()
+	defer server.Close()
+
+	kbps, size, conns := 800, 25000, 2
+	p, client := shapedClient(t, &Shaping{DownloadKbps: kbps})
+	defer p.Close()
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < conns; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := timedGet(client, server, size); err != nil {
+				t.Errorf("failed to get: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	want := transmitTime(conns*size, kbps)
+	if elapsed := time.Since(start); !within(elapsed, want) {
+		t.Errorf("%d connections of %d bytes took %s, want about %s", conns, size, elapsed, want)
+	}
+}
+
+func TestShapingUnlimited(t *testing.T) {
+	server := bodyServer()
+	defer server.Close()
+
+	p, client := shapedClient(t, &Shaping{})
+	defer p.Close()
+
+	// Even a slow link would take this long for what is a moment unshaped
+	size, slowKbps := 10000000, 20000
+	_, rest, err := timedGet(client, server, size)
+	if err != nil {
+		t.Fatalf("failed to get: %v", err)
+	}
+
+	if limit := transmitTime(size, slowKbps); rest > limit {
+		t.Errorf("%d bytes took %s without a throughput limit, want less than %s", size, rest, limit)
+	}
+}
+
+func TestShapingCloseDropsQueuedData(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer l.Close()
+
+	// The peer never reads, and the data would take minutes to send
+	go func() {
+		if conn, err := l.Accept(); err == nil {
+			defer conn.Close()
+			time.Sleep(time.Minute)
+		}
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+
+	latency := 100 * time.Millisecond
+	c := newShapedConn(conn, latency, &bandwidth{}, &bandwidth{kbps: 8})
+	written := make(chan error, 1)
+	go func() {
+		_, err := c.Write(make([]byte, linkBuffer*packetSize))
+		written <- err
+	}()
+	time.Sleep(latency)
+
+	start := time.Now()
+	if err = c.Close(); err != nil {
+		t.Errorf("failed to close: %v", err)
+	}
+
+	limit := upperTolerance * (latency/2 + closeDrainLimit)
+	if elapsed := time.Since(start); elapsed > limit {
+		t.Errorf("close took %s, want less than %s", elapsed, limit)
+	}
+	if err = <-written; err == nil {
+		t.Error("write succeeded after close")
+	}
+}
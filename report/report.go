@@ -0,0 +1,259 @@
+package report
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jordanpotter/site-analyzer/browser"
+	"github.com/jordanpotter/site-analyzer/utils"
+	"github.com/jordanpotter/site-analyzer/video"
+	"github.com/pkg/errors"
+)
+
+const (
+	reportFilename         = "report.html"
+	waterfallMinPhaseWidth = 0.1
+)
+
+// Report gathers the results of a run into a single html page. The thumbnail
+// is embedded so the page can be shared on its own, while the video is
+// linked relative to the report.
+type Report struct {
+	URL            string
+	Start          time.Time
+	Analysis       *browser.Analysis
+	VisualProgress *video.VisualProgress
+	ThumbnailPath  string
+	VideoPath      string
+}
+
+type timing struct {
+	Name  string
+	Value string
+}
+
+type waterfallRow struct {
+	URL      string
+	Status   int
+	Type     string
+	Size     string
+	Duration string
+	Failed   bool
+	Blocked  bool
+	Phases   []waterfallPhase
+}
+
+type waterfallPhase struct {
+	Name  string
+	Left  float64
+	Width float64
+}
+
+func (r *Report) Save(ctx context.Context, dir string) (string, error) {
+	var path string
+	var err error
+
+	c := make(chan bool, 1)
+	go func() {
+		path, err = r.doSave(dir)
+		c <- true
+	}()
+
+	select {
+	case <-c:
+		return path, err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func (r *Report) doSave(dir string) (string, error) {
+	t, err := template.New("report").Parse(reportTemplate)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to parse template")
+	}
+
+	thumbnail, err := r.thumbnail()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to embed thumbnail")
+	}
+
+	data := map[string]interface{}{
+		"url":             r.URL,
+		"start":           r.Start.Format(time.RFC1123),
+		"thumbnail":       thumbnail,
+		"video":           relativePath(dir, r.VideoPath),
+		"cpuThrottling":   r.cpuThrottling(),
+		"timings":         r.timings(),
+		"waterfall":       r.waterfall(),
+		"networkMeasured": r.Analysis.NetworkMeasured,
+		"consoleErrors":   r.consoleErrors(),
+	}
+
+	path := filepath.Join(dir, reportFilename)
+	f, err := os.Create(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to create file %s", path)
+	}
+	defer utils.MustFunc(f.Close)
+
+	if err = t.Execute(f, data); err != nil {
+		return "", errors.Wrapf(err, "failed to write template to file %s", path)
+	}
+
+	return path, nil
+}
+
+func (r *Report) thumbnail() (template.URL, error) {
+	if r.ThumbnailPath == "" {
+		return "", nil
+	}
+
+	data, err := ioutil.ReadFile(r.ThumbnailPath)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read file %s", r.ThumbnailPath)
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(data)
+	return template.URL("data:image/png;base64," + encoded), nil
+}
+
+// cpuThrottling describes how much slower the CPU was made, or is empty when
+// it was not throttled.
+func (r *Report) cpuThrottling() string {
+	if r.Analysis.CPUThrottlingRate <= 1 {
+		return ""
+	}
+	return fmt.Sprintf("%gx", r.Analysis.CPUThrottlingRate)
+}
+
+func (r *Report) timings() []timing {
+	a := r.Analysis
+	timings := []timing{
+		{"Page load", formatDuration(a.PageLoadTime)},
+	}
+
+	if m := a.Metrics; m != nil {
+		timings = append(timings,
+			timing{"First paint", formatDuration(m.FirstPaint)},
+			timing{"First contentful paint", formatDuration(m.FirstContentfulPaint)},
+			timing{"Largest contentful paint", formatDuration(m.LargestContentfulPaint)},
+			timing{"Cumulative layout shift", fmt.Sprintf("%.3f", m.CumulativeLayoutShift)},
+			timing{"DOMContentLoaded", formatDuration(m.DOMContentLoaded)},
+			timing{"Load event", formatDuration(m.LoadEvent)},
+		)
+	}
+
+	if vp := r.VisualProgress; vp != nil {
+		timings = append(timings,
+			timing{"Speed index", formatDuration(vp.SpeedIndex)},
+			timing{"First visual change", formatDuration(vp.FirstVisualChange)},
+			timing{"Last visual change", formatDuration(vp.LastVisualChange)},
+		)
+	}
+
+	return timings
+}
+
+func (r *Report) waterfall() []waterfallRow {
+	var total time.Duration
+	for i := range r.Analysis.Waterfall {
+		if end := r.Analysis.Waterfall[i].End(); end > total {
+			total = end
+		}
+	}
+	if total == 0 {
+		return nil
+	}
+
+	rows := make([]waterfallRow, 0, len(r.Analysis.Waterfall))
+	for _, entry := range r.Analysis.Waterfall {
+		row := waterfallRow{
+			URL:      entry.URL,
+			Status:   entry.Status,
+			Type:     entry.ResourceType,
+			Size:     formatBytes(entry.TransferSize),
+			Duration: formatDuration(entry.Duration),
+			Failed:   entry.Failed,
+			Blocked:  entry.Blocked,
+		}
+
+		offset := entry.Start
+		phases := []struct {
+			name     string
+			duration time.Duration
+		}{
+			{"queueing", entry.Queueing},
+			{"dns", entry.DNS},
+			{"connect", entry.Connect},
+			{"ssl", entry.SSL},
+			{"send", entry.Send},
+			{"wait", entry.Wait},
+			{"receive", entry.Receive},
+		}
+		for _, phase := range phases {
+			if phase.duration <= 0 {
+				continue
+			}
+			row.Phases = append(row.Phases, waterfallPhase{
+				Name:  phase.name,
+				Left:  percent(offset, total),
+				Width: maxFloat(percent(phase.duration, total), waterfallMinPhaseWidth),
+			})
+			offset += phase.duration
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows
+}
+
+func (r *Report) consoleErrors() []browser.ConsoleLogEntry {
+	if r.Analysis.ConsoleLog == nil {
+		return nil
+	}
+	return r.Analysis.ConsoleLog.Errors()
+}
+
+func relativePath(dir, path string) string {
+	if rel, err := filepath.Rel(dir, path); err == nil {
+		return rel
+	}
+	return path
+}
+
+func formatDuration(d time.Duration) string {
+	if d == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.0f ms", d.Seconds()*1000)
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", n)
+	}
+}
+
+func percent(d, total time.Duration) float64 {
+	return 100 * float64(d) / float64(total)
+}
+
+func maxFloat(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,61 @@
+package report
+
+const reportTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.url}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h1 { font-size: 1.4em; word-break: break-all; }
+h2 { font-size: 1.1em; margin-top: 2em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: 2px 8px; border-bottom: 1px solid #eee; font-size: 0.85em; }
+div.frame { padding-left: 2em; font-family: monospace; }
+td.url { max-width: 40em; overflow: hidden; text-overflow: ellipsis; white-space: nowrap; }
+tr.failed td { color: #c00; }
+.thumbnail { max-width: 640px; border: 1px solid #ccc; }
+.bar { position: relative; width: 30em; height: 1em; }
+.phase { position: absolute; top: 0; height: 100%; }
+.queueing { background: #ccc; }
+.dns { background: #1f9e89; }
+.connect { background: #f89a3e; }
+.ssl { background: #c678dd; }
+.send { background: #666; }
+.wait { background: #4caf50; }
+.receive { background: #2196f3; }
+.legend span { display: inline-block; padding: 0 6px; margin-right: 4px; color: #fff; font-size: 0.8em; }
+</style>
+</head>
+<body>
+<h1>{{.url}}</h1>
+<p>Analyzed {{.start}}</p>
+{{if .cpuThrottling}}<p>CPU slowed down {{.cpuThrottling}}</p>{{end}}
+
+{{if .thumbnail}}<p><img class="thumbnail" src="{{.thumbnail}}" alt="Page when loaded"></p>{{end}}
+{{if .video}}<p><a href="{{.video}}">Watch the video of the page load</a></p>{{end}}
+
+<h2>Timings</h2>
+<table>
+{{range .timings}}<tr><th>{{.Name}}</th><td>{{.Value}}</td></tr>
+{{end}}</table>
+
+<h2>Network waterfall</h2>
+{{if .networkMeasured}}<p class="legend">
+<span class="queueing">queueing</span><span class="dns">dns</span><span class="connect">connect</span><span class="ssl">ssl</span><span class="send">send</span><span class="wait">wait</span><span class="receive">receive</span>
+</p>
+<table>
+<tr><th>URL</th><th>Status</th><th>Type</th><th>Size</th><th>Time</th><th></th></tr>
+{{range .waterfall}}<tr{{if .Failed}} class="failed"{{end}}>
+<td class="url" title="{{.URL}}">{{.URL}}</td><td>{{if .Blocked}}blocked{{else}}{{.Status}}{{end}}</td><td>{{.Type}}</td><td>{{.Size}}</td><td>{{.Duration}}</td>
+<td><div class="bar">{{range .Phases}}<div class="phase {{.Name}}" style="left: {{printf "%.3f" .Left}}%; width: {{printf "%.3f" .Width}}%"></div>{{end}}</div></td>
+</tr>
+{{end}}</table>{{else}}<p>Not measured by this browser</p>{{end}}
+
+<h2>Console errors</h2>
+{{if .consoleErrors}}<table>
+{{range .consoleErrors}}<tr><td>{{.Time.Format "15:04:05.000"}}</td><td>{{.Kind}}</td><td>{{.Source}}{{if .Line}}:{{.Line}}{{if .Column}}:{{.Column}}{{end}}{{end}}</td><td>{{.Text}}{{range .Stack}}<div class="frame">at {{.}}</div>{{end}}</td></tr>
+{{end}}</table>{{else}}<p>None</p>{{end}}
+</body>
+</html>
+`
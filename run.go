@@ -0,0 +1,670 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/jordanpotter/site-analyzer/browser"
+	"github.com/jordanpotter/site-analyzer/budget"
+	"github.com/jordanpotter/site-analyzer/display"
+	"github.com/jordanpotter/site-analyzer/report"
+	"github.com/jordanpotter/site-analyzer/video"
+)
+
+const repeatViewDirname = "repeat-view"
+
+type job struct {
+	URL               string              `json:"url"`
+	LoadedSpec        *browser.LoadedSpec `json:"loadedSpec"`
+	Browser           string              `json:"browser"`
+	Device            string              `json:"device"`
+	Network           string              `json:"network"`
+	Latency           string              `json:"latency"`
+	DownloadKbps      int                 `json:"downloadKbps"`
+	UploadKbps        int                 `json:"uploadKbps"`
+	ThrottleWith      string              `json:"throttleWith"`
+	CPUThrottlingRate float64             `json:"cpuThrottlingRate"`
+	Journey           *browser.Journey    `json:"journey"`
+	State             *browser.State      `json:"state"`
+	UserAgent         string              `json:"userAgent"`
+	Headers           map[string]string   `json:"headers"`
+	BlockedURLs       []string            `json:"blockedUrls"`
+	Hosts             map[string]string   `json:"hosts"`
+	Record            string              `json:"record"`
+	Replay            string              `json:"replay"`
+	Width             int                 `json:"width"`
+	Height            int                 `json:"height"`
+	Budget            *budget.Budget      `json:"budget"`
+
+	dir     string
+	log     *log.Logger
+	device  *browser.Device
+	latency time.Duration
+	network *browser.NetworkConditions
+}
+
+// setDefaults fills in the options the job does not override. A device
+// profile is emulated within a display of the default width and height, and
+// only its viewport is captured.
+func (j *job) setDefaults() error {
+	if j.LoadedSpec == nil {
+		j.LoadedSpec = &browser.LoadedSpec{}
+	}
+	if j.Browser == "" {
+		j.Browser = browserName
+	}
+	if j.Device == "" {
+		j.Device = deviceName
+	}
+	if j.Device != "" {
+		var err error
+		if j.device, err = browser.LookupDevice(j.Device); err != nil {
+			return errors.Wrap(err, "failed to find device")
+		}
+	}
+	j.latency = latency
+	if j.Latency != "" {
+		var err error
+		if j.latency, err = time.ParseDuration(j.Latency); err != nil {
+			return errors.Wrapf(err, "failed to parse latency %q", j.Latency)
+		} else if j.latency < 0 {
+			return errors.Errorf("invalid latency %s", j.latency)
+		}
+	}
+	if j.DownloadKbps == 0 {
+		j.DownloadKbps = downloadKbps
+	} else if j.DownloadKbps < 0 {
+		return errors.Errorf("invalid download throughput %d", j.DownloadKbps)
+	}
+	if j.UploadKbps == 0 {
+		j.UploadKbps = uploadKbps
+	} else if j.UploadKbps < 0 {
+		return errors.Errorf("invalid upload throughput %d", j.UploadKbps)
+	}
+	if j.Network == "" {
+		j.Network = networkName
+	}
+	// Latency or throughput without a preset describe the network entirely
+	if j.Network == "" && (j.latency > 0 || j.DownloadKbps > 0 || j.UploadKbps > 0) {
+		j.Network = customNetwork
+	}
+	if j.Network != "" {
+		var err error
+		if j.network, err = networkConditions(j.Network, j.latency, j.DownloadKbps, j.UploadKbps); err != nil {
+			return errors.Wrap(err, "failed to find network conditions")
+		}
+	}
+	if j.ThrottleWith == "" {
+		j.ThrottleWith = throttleWith
+	}
+	if j.ThrottleWith == "" {
+		// Only Chrome can throttle itself
+		j.ThrottleWith = proxyThrottle
+		if j.Browser == chromeBrowser {
+			j.ThrottleWith = devToolsThrottle
+		}
+	} else if j.ThrottleWith != devToolsThrottle && j.ThrottleWith != proxyThrottle {
+		return errors.Errorf("unexpected throttle %q", j.ThrottleWith)
+	}
+	if j.CPUThrottlingRate == 0 {
+		j.CPUThrottlingRate = cpuThrottlingRate
+	}
+	if j.Width == 0 {
+		j.Width = width
+	}
+	if j.Height == 0 {
+		j.Height = height
+	}
+	if j.Budget == nil {
+		j.Budget = defaultBudget
+	}
+	if j.State == nil {
+		j.State = defaultState
+	}
+	if j.UserAgent == "" {
+		j.UserAgent = userAgent
+	}
+	if j.Headers == nil {
+		j.Headers = headers
+	}
+	if j.BlockedURLs == nil {
+		j.BlockedURLs = blockedURLs
+	}
+	if j.Hosts == nil {
+		j.Hosts = hosts
+	}
+	for host, ip := range j.Hosts {
+		if net.ParseIP(ip) == nil {
+			return errors.Errorf("invalid ip %q for host %q", ip, host)
+		}
+	}
+	if j.Record == "" && j.Replay == "" {
+		j.Record, j.Replay = recordPath, replayPath
+	}
+	if j.Record != "" && j.Replay != "" {
+		return errors.New("cannot both record and replay")
+	} else if j.Record != "" && runs > 1 {
+		return errors.New("cannot record more than one run")
+	}
+	if j.Journey == nil {
+		j.Journey = defaultJourney
+	} else if err := j.Journey.Validate(); err != nil {
+		return errors.Wrap(err, "invalid journey")
+	}
+	return nil
+}
+
+// networkConditions looks up the named preset, or starts from no throttling
+// for custom conditions, and applies the given latency and throughputs on top.
+func networkConditions(name string, latency time.Duration, downloadKbps, uploadKbps int) (*browser.NetworkConditions, error) {
+	nc := &browser.NetworkConditions{Name: customNetwork}
+	if name != customNetwork {
+		var err error
+		if nc, err = browser.LookupNetworkConditions(name); err != nil {
+			return nil, err
+		}
+	}
+
+	if latency > 0 {
+		nc.Latency = latency
+	}
+	if downloadKbps > 0 {
+		nc.DownloadKbps = downloadKbps
+	}
+	if uploadKbps > 0 {
+		nc.UploadKbps = uploadKbps
+	}
+	return nc, nil
+}
+
+// devToolsNetwork is the job's network conditions when the browser throttles
+// the network rather than the proxy.
+func devToolsNetwork(j *job) *browser.NetworkConditions {
+	if j.ThrottleWith != devToolsThrottle {
+		return nil
+	}
+	return j.network
+}
+
+type budgetExceededError struct {
+	violations []budget.Violation
+}
+
+func (e *budgetExceededError) Error() string {
+	messages := make([]string, 0, len(e.violations))
+	for _, violation := range e.violations {
+		messages = append(messages, violation.Message)
+	}
+	return fmt.Sprintf("budget exceeded: %s", strings.Join(messages, "; "))
+}
+
+func isBudgetExceeded(err error) bool {
+	_, ok := err.(*budgetExceededError)
+	return ok
+}
+
+// runResult is what a single successful run produced.
+type runResult struct {
+	analysis       *browser.Analysis
+	visualProgress *video.VisualProgress
+	videoPath      string
+}
+
+func run(j *job) error {
+	if err := j.setDefaults(); err != nil {
+		return err
+	}
+
+	if runs > 1 {
+		return runRepeatedly(j, runs)
+	}
+
+	_, err := runOnce(j)
+	return err
+}
+
+func runOnce(j *job) (*runResult, error) {
+	s := newSummary(j.URL, j.dir)
+	result, err := analyzeAndSave(j, s)
+	s.End = time.Now()
+	if err != nil {
+		s.Error = err.Error()
+	}
+
+	j.log.Println("Saving summary...")
+	summaryPath, saveErr := s.save()
+	if saveErr != nil {
+		j.log.Printf("Unexpected error while saving summary: %v", saveErr)
+	} else {
+		j.log.Printf("Summary saved to %s", summaryPath)
+	}
+
+	if violations := s.allBudgetViolations(); err == nil && len(violations) > 0 {
+		return result, &budgetExceededError{violations}
+	}
+	return result, err
+}
+
+// unmeasuredNetworkResults are the results left out of the summary when the
+// browser does not report its network activity.
+var unmeasuredNetworkResults = []string{"performance_log", "har", "waterfall", "requests", "transferBytes"}
+
+// view is a single analyzed page load and the video captured during it,
+// along with the journey steps performed around it. The capture continues
+// through the steps after the load, so loaded is the location in the capture
+// where the load completed.
+type view struct {
+	analysis *browser.Analysis
+	capture  *video.Capture
+	start    time.Time
+	origin   time.Duration
+	loaded   time.Duration
+	steps    []stepResult
+}
+
+func analyzeAndSave(j *job, s *summary) (*runResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if ffmpegVersion, err := video.FFmpegVersion(ctx); err != nil {
+		j.log.Printf("Unable to determine ffmpeg version: %v", err)
+	} else {
+		s.Versions["ffmpeg"] = ffmpegVersion
+	}
+
+	j.log.Printf("Analyzing %q...", j.URL)
+	firstView, secondView, err := analyzeAndCapture(ctx, j, s)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := saveView(ctx, j, s, firstView, j.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if secondView == nil {
+		return result, nil
+	}
+
+	j.log.Println("Saving repeat view...")
+	dir := filepath.Join(j.dir, repeatViewDirname)
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrapf(err, "failed to create directory %s", dir)
+	}
+
+	s.RepeatView = &summary{
+		URL:       j.URL,
+		Start:     secondView.start,
+		Artifacts: make(map[string]string),
+		dir:       j.dir,
+	}
+	if _, err = saveView(ctx, j, s.RepeatView, secondView, dir); err != nil {
+		return nil, errors.Wrap(err, "failed to save repeat view")
+	}
+	s.RepeatView.End = time.Now()
+
+	logCacheSavings(j.log, firstView.analysis, secondView.analysis)
+
+	return result, nil
+}
+
+func saveView(ctx context.Context, j *job, s *summary, v *view, dir string) (*runResult, error) {
+	analysis, capture, origin, loaded := v.analysis, v.capture, v.origin, v.loaded
+
+	s.PageLoadTimeMs = milliseconds(analysis.PageLoadTime)
+	s.ConsoleLogEntries = len(analysis.ConsoleLog.Entries)
+	s.ConsoleErrorsBySource = analysis.ConsoleLog.ErrorsBySource()
+	s.PerformanceLogEntries = len(analysis.PerformanceLog.Entries)
+	for _, entry := range analysis.Waterfall {
+		if entry.Blocked {
+			s.BlockedRequests++
+		}
+	}
+
+	j.log.Printf("Saving console logs...")
+	consoleLogPath, err := analysis.ConsoleLog.Save(ctx, dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to save console log")
+	}
+	s.addArtifact("console_log", consoleLogPath)
+
+	var performanceLogPath, harPath string
+	if analysis.NetworkMeasured {
+		j.log.Printf("Saving performance logs...")
+		performanceLogPath, err = analysis.PerformanceLog.Save(ctx, dir)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to save performance log")
+		}
+		s.addArtifact("performance_log", performanceLogPath)
+
+		j.log.Printf("Saving HAR...")
+		harPath, err = analysis.PerformanceLog.SaveHAR(ctx, dir, version)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to save HAR")
+		}
+		s.addArtifact("har", harPath)
+	} else {
+		j.log.Printf("Network activity was not measured by %s", j.Browser)
+		s.Unsupported = unmeasuredNetworkResults
+	}
+
+	j.log.Println("Saving video...")
+	videoPath, err := capture.SaveVideo(ctx, dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to save video")
+	}
+	s.addArtifact("video", videoPath)
+
+	j.log.Println("Saving thumbnail...")
+	thumbnailPath, err := capture.SaveThumbnail(ctx, origin+analysis.PageLoadTime, dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to save thumbnail")
+	}
+	s.addArtifact("thumbnail", thumbnailPath)
+
+	j.log.Println("Analyzing visual progress...")
+	visualProgress, err := capture.AnalyzeVisualProgress(ctx, origin, loaded)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to analyze visual progress")
+	}
+
+	if j.Budget != nil {
+		j.log.Println("Checking budget...")
+		s.BudgetViolations = j.Budget.Check(budget.Measure(analysis, visualProgress))
+		for _, violation := range s.BudgetViolations {
+			j.log.Printf("Budget violation: %s", violation.Message)
+		}
+	}
+
+	j.log.Println("Saving filmstrip...")
+	// The filmstrip stops at the last visual change, or otherwise where the
+	// load completed, rather than running on through any journey steps
+	filmstripEnd := loaded
+	if visualProgress.LastVisualChange > 0 {
+		filmstripEnd = origin + visualProgress.LastVisualChange
+	}
+	filmstripPath, err := capture.SaveFilmstrip(ctx, filmstripInterval, origin, filmstripEnd, dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to save filmstrip")
+	}
+	s.addArtifact("filmstrip", filmstripPath)
+
+	if len(v.steps) > 0 {
+		j.log.Println("Saving journey...")
+		if err = saveJourney(ctx, s, v.steps, capture, dir); err != nil {
+			return nil, errors.Wrap(err, "failed to save journey")
+		}
+	}
+
+	j.log.Println("Saving report...")
+	r := &report.Report{
+		URL:            j.URL,
+		Start:          s.Start,
+		Analysis:       analysis,
+		VisualProgress: visualProgress,
+		ThumbnailPath:  thumbnailPath,
+		VideoPath:      videoPath,
+	}
+	reportPath, err := r.Save(ctx, dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to save report")
+	}
+	s.addArtifact("report", reportPath)
+
+	j.log.Printf("Page took %f seconds to load", analysis.PageLoadTime.Seconds())
+	j.log.Printf("First paint after %f seconds", analysis.Metrics.FirstPaint.Seconds())
+	j.log.Printf("First contentful paint after %f seconds", analysis.Metrics.FirstContentfulPaint.Seconds())
+	j.log.Printf("Largest contentful paint after %f seconds", analysis.Metrics.LargestContentfulPaint.Seconds())
+	j.log.Printf("Cumulative layout shift of %f", analysis.Metrics.CumulativeLayoutShift)
+	j.log.Printf("Speed index of %f seconds", visualProgress.SpeedIndex.Seconds())
+	j.log.Printf("First visual change after %f seconds", visualProgress.FirstVisualChange.Seconds())
+	j.log.Printf("Last visual change after %f seconds", visualProgress.LastVisualChange.Seconds())
+	j.log.Printf("DOMContentLoaded after %f seconds", analysis.Metrics.DOMContentLoaded.Seconds())
+	j.log.Printf("Load event after %f seconds", analysis.Metrics.LoadEvent.Seconds())
+	j.log.Printf("Received %d console log entries", len(analysis.ConsoleLog.Entries))
+	j.log.Printf("Received %d performance log entries", len(analysis.PerformanceLog.Entries))
+	if analysis.NetworkMeasured {
+		j.log.Printf("Page made %d requests", len(analysis.Waterfall))
+	}
+	if s.BlockedRequests > 0 {
+		j.log.Printf("Blocked %d requests", s.BlockedRequests)
+	}
+	logSlowestRequests(j.log, analysis.Waterfall, 5)
+
+	j.log.Printf("Console log saved to %s", consoleLogPath)
+	if analysis.NetworkMeasured {
+		j.log.Printf("Performance log saved to %s", performanceLogPath)
+		j.log.Printf("HAR saved to %s", harPath)
+	}
+	j.log.Printf("Video saved to %s", videoPath)
+	j.log.Printf("Thumbnail saved to %s", thumbnailPath)
+	j.log.Printf("Filmstrip saved to %s", filmstripPath)
+	j.log.Printf("Report saved to %s", reportPath)
+
+	return &runResult{analysis, visualProgress, videoPath}, nil
+}
+
+// analyzeAndCapture analyzes the page while capturing a video of it. With
+// repeat views enabled, the browser then navigates away and analyzes the page
+// again in the same session to measure it with a warm cache.
+func analyzeAndCapture(ctx context.Context, j *job, s *summary) (firstView, secondView *view, err error) {
+	j.log.Println("Creating the display...")
+	d, err := display.New(ctx, j.Width, j.Height)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to create display")
+	}
+	defer closeOnReturn(&err, d.Close, "failed to close display")
+
+	var proxyAddr string
+	if needsProxy(j) {
+		j.log.Println("Starting the proxy...")
+		p, archive, openErr := openProxy(j)
+		if openErr != nil {
+			return nil, nil, openErr
+		}
+		defer closeOnReturn(&err, func() error { return closeProxy(j, s, p, archive) }, "failed to close proxy")
+		proxyAddr = p.Addr()
+	}
+
+	j.log.Printf("Opening %s...", j.Browser)
+	opts := &browser.Options{
+		Width:      j.Width,
+		Height:     j.Height,
+		DisplayNum: d.Num,
+		LogsDir:    j.dir,
+		Device:     j.device,
+		Network:    devToolsNetwork(j),
+
+		CPUThrottlingRate: j.CPUThrottlingRate,
+		UserAgent:         j.UserAgent,
+		Headers:           j.Headers,
+		BlockedURLs:       j.BlockedURLs,
+		Proxy:             proxyAddr,
+		Hosts:             browserHosts(j),
+	}
+	b, err := openBrowser(ctx, j.Browser, opts)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to create browser")
+	}
+	defer closeOnReturn(&err, b.Close, "failed to close browser")
+
+	s.Browser = b.Name()
+	s.Device = j.Device
+	if j.network != nil {
+		s.Network = newNetworkSummary(j.network, j.ThrottleWith)
+	}
+	if j.CPUThrottlingRate > 1 {
+		s.CPUThrottlingRate = j.CPUThrottlingRate
+	}
+	s.UserAgent = j.UserAgent
+	s.Headers = redactHeaders(j.Headers)
+	s.BlockedURLs = j.BlockedURLs
+	s.Hosts = j.Hosts
+
+	s.Versions["browser"] = b.Version()
+	s.Versions["driver"] = b.DriverVersion()
+
+	if j.State != nil {
+		j.log.Println("Priming cookies and localStorage...")
+		origin, err := originOf(j.URL)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		loaded, err := b.Prime(ctx, origin, j.State)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to prime %q", origin)
+		} else if loaded {
+			j.log.Printf("Loaded %q to prime it, so its connections are warm for the first view", origin)
+		}
+	}
+
+	var beforeSteps []stepResult
+	if j.Journey != nil && len(j.Journey.Before) > 0 {
+		j.log.Println("Running journey steps before the page load...")
+		if beforeSteps, err = runBeforeSteps(ctx, j, b); err != nil {
+			return nil, nil, err
+		}
+
+		// Keep the steps out of the logs and video of the page load
+		if err = b.NavigateAway(ctx); err != nil {
+			return nil, nil, errors.Wrap(err, "failed to navigate away")
+		}
+	}
+
+	firstView, err = analyzeView(ctx, j, b, d.Num, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	firstView.steps = append(beforeSteps, firstView.steps...)
+
+	if !repeatView {
+		return firstView, nil, nil
+	}
+
+	j.log.Println("Navigating away for the repeat view...")
+	if err = b.NavigateAway(ctx); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to navigate away")
+	}
+
+	secondView, err = analyzeView(ctx, j, b, d.Num, false)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to analyze repeat view")
+	}
+
+	return firstView, secondView, nil
+}
+
+// closeOnReturn calls f when deferred, and reports its failure through err
+// unless an earlier error is already being returned. Failing the job rather
+// than the process keeps other jobs in a batch running.
+func closeOnReturn(err *error, f func() error, message string) {
+	if closeErr := f(); closeErr != nil && *err == nil {
+		*err = errors.Wrap(closeErr, message)
+	}
+}
+
+// originOf is the scheme and host of rawURL.
+func originOf(rawURL string) (string, error) {
+	u, err := neturl.Parse(rawURL)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to parse url %q", rawURL)
+	} else if u.Scheme == "" || u.Host == "" {
+		return "", errors.Errorf("url %q has no origin", rawURL)
+	}
+	return u.Scheme + "://" + u.Host, nil
+}
+
+func openBrowser(ctx context.Context, name string, opts *browser.Options) (*browser.Browser, error) {
+	switch name {
+	case chromeBrowser:
+		return browser.NewChrome(ctx, chromeDriverPath, opts)
+	case firefoxBrowser:
+		return browser.NewFirefox(ctx, firefoxPath, firefoxXPIPath, opts)
+	default:
+		return nil, errors.Errorf("unknown browser %q", name)
+	}
+}
+
+// analyzeView analyzes the page while capturing a video of it, followed by
+// the journey's after steps when requested.
+func analyzeView(ctx context.Context, j *job, b *browser.Browser, displayNum int, withJourney bool) (v *view, err error) {
+	j.log.Println("Starting video capture...")
+	viewport := b.Viewport()
+	capture, err := video.StartCapture(ctx, displayNum, viewport.X, viewport.Y, viewport.Width, viewport.Height, fps)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to start video capture")
+	}
+	defer closeOnReturn(&err, capture.Stop, "failed to stop video capture")
+
+	start := time.Now()
+
+	j.log.Println("Performing analysis...")
+	analysis, err := b.Analyze(ctx, j.URL, j.LoadedSpec, 10*time.Second)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to analyze %q", j.URL)
+	}
+	loaded := capture.Offset(time.Now())
+
+	var steps []stepResult
+	if withJourney && j.Journey != nil && len(j.Journey.After) > 0 {
+		j.log.Println("Running journey steps after the page load...")
+		if steps, err = runAfterSteps(ctx, j, b, capture); err != nil {
+			return nil, err
+		}
+	}
+
+	return &view{analysis, capture, start, navigationOrigin(analysis, capture), loaded, steps}, nil
+}
+
+// navigationOrigin is the location of navigation start in the capture, or the
+// start of the capture when the browser did not report it.
+func navigationOrigin(analysis *browser.Analysis, capture *video.Capture) time.Duration {
+	if analysis.Metrics.NavigationStart.IsZero() {
+		return 0
+	}
+	return capture.Offset(analysis.Metrics.NavigationStart)
+}
+
+func logCacheSavings(logger *log.Logger, firstView, repeatView *browser.Analysis) {
+	transferSize := func(analysis *browser.Analysis) int64 {
+		var total int64
+		for _, entry := range analysis.Waterfall {
+			total += entry.TransferSize
+		}
+		return total
+	}
+
+	logger.Printf("Repeat view took %f seconds to load, compared to %f seconds for the first view",
+		repeatView.PageLoadTime.Seconds(), firstView.PageLoadTime.Seconds())
+	logger.Printf("Repeat view transferred %d bytes in %d requests, compared to %d bytes in %d requests for the first view",
+		transferSize(repeatView), len(repeatView.Waterfall), transferSize(firstView), len(firstView.Waterfall))
+}
+
+func logSlowestRequests(logger *log.Logger, waterfall []browser.WaterfallEntry, n int) {
+	entries := make([]browser.WaterfallEntry, len(waterfall))
+	copy(entries, waterfall)
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Duration > entries[j].Duration
+	})
+
+	if len(entries) > n {
+		entries = entries[:n]
+	}
+
+	for _, entry := range entries {
+		logger.Printf("Request took %f seconds (dns %s, connect %s, ssl %s, wait %s, receive %s): %s",
+			entry.Duration.Seconds(), entry.DNS, entry.Connect, entry.SSL, entry.Wait, entry.Receive, entry.URL)
+	}
+}
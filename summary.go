@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jordanpotter/site-analyzer/browser"
+	"github.com/jordanpotter/site-analyzer/budget"
+	"github.com/jordanpotter/site-analyzer/utils"
+	"github.com/pkg/errors"
+)
+
+const (
+	summaryFilename = "summary.json"
+	redactedValue   = "REDACTED"
+)
+
+// sensitiveHeaders are recorded without their values, since summaries are
+// shared more widely than the credentials they would contain.
+var sensitiveHeaders = []string{"authorization", "proxy-authorization", "cookie", "token", "secret", "key"}
+
+// summary is the machine readable result of a run. Durations are in
+// milliseconds and artifact paths are relative to the summary.
+type summary struct {
+	URL                   string             `json:"url"`
+	Browser               string             `json:"browser,omitempty"`
+	Device                string             `json:"device,omitempty"`
+	Network               *networkSummary    `json:"network,omitempty"`
+	CPUThrottlingRate     float64            `json:"cpuThrottlingRate,omitempty"`
+	UserAgent             string             `json:"userAgent,omitempty"`
+	Headers               map[string]string  `json:"headers,omitempty"`
+	BlockedURLs           []string           `json:"blockedUrls,omitempty"`
+	Hosts                 map[string]string  `json:"hosts,omitempty"`
+	Start                 time.Time          `json:"start"`
+	End                   time.Time          `json:"end"`
+	PageLoadTimeMs        float64            `json:"pageLoadTimeMs,omitempty"`
+	ConsoleLogEntries     int                `json:"consoleLogEntries"`
+	ConsoleErrorsBySource map[string]int     `json:"consoleErrorsBySource,omitempty"`
+	PerformanceLogEntries int                `json:"performanceLogEntries"`
+	Unsupported           []string           `json:"unsupported,omitempty"`
+	BlockedRequests       int                `json:"blockedRequests,omitempty"`
+	ReplayMisses          int                `json:"replayMisses,omitempty"`
+	Artifacts             map[string]string  `json:"artifacts"`
+	Versions              map[string]string  `json:"versions,omitempty"`
+	BudgetViolations      []budget.Violation `json:"budgetViolations,omitempty"`
+	Journey               []stepSummary      `json:"journey,omitempty"`
+	RepeatView            *summary           `json:"repeatView,omitempty"`
+	Error                 string             `json:"error,omitempty"`
+
+	dir string
+}
+
+// networkSummary records the throttling a run was measured under.
+type networkSummary struct {
+	Name          string  `json:"name"`
+	ThrottledWith string  `json:"throttledWith"`
+	LatencyMs     float64 `json:"latencyMs"`
+	DownloadKbps  int     `json:"downloadKbps"`
+	UploadKbps    int     `json:"uploadKbps"`
+}
+
+func newNetworkSummary(nc *browser.NetworkConditions, throttledWith string) *networkSummary {
+	return &networkSummary{
+		Name:          nc.Name,
+		ThrottledWith: throttledWith,
+		LatencyMs:     milliseconds(nc.Latency),
+		DownloadKbps:  nc.DownloadKbps,
+		UploadKbps:    nc.UploadKbps,
+	}
+}
+
+func newSummary(url, dir string) *summary {
+	return &summary{
+		URL:       url,
+		Start:     time.Now(),
+		Artifacts: make(map[string]string),
+		Versions:  map[string]string{"site-analyzer": version},
+		dir:       dir,
+	}
+}
+
+func (s *summary) addArtifact(name, path string) {
+	if rel, err := filepath.Rel(s.dir, path); err == nil {
+		path = rel
+	}
+	s.Artifacts[name] = path
+}
+
+// allBudgetViolations are the violations of the first view followed by those
+// of the repeat view, which are marked as such.
+func (s *summary) allBudgetViolations() []budget.Violation {
+	violations := make([]budget.Violation, 0, len(s.BudgetViolations))
+	violations = append(violations, s.BudgetViolations...)
+	if s.RepeatView != nil {
+		for _, violation := range s.RepeatView.BudgetViolations {
+			violation.Message = "repeat view: " + violation.Message
+			violations = append(violations, violation)
+		}
+	}
+	return violations
+}
+
+func (s *summary) save() (string, error) {
+	path := filepath.Join(s.dir, summaryFilename)
+	f, err := os.Create(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to create file %s", path)
+	}
+	defer utils.MustFunc(f.Close)
+
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	if err = encoder.Encode(s); err != nil {
+		return "", errors.Wrapf(err, "failed to write json to file %s", path)
+	}
+
+	return path, nil
+}
+
+// redactHeaders copies headers, replacing the values of those whose names
+// suggest they carry credentials.
+func redactHeaders(headers map[string]string) map[string]string {
+	if len(headers) == 0 {
+		return nil
+	}
+
+	redacted := make(map[string]string, len(headers))
+	for name, value := range headers {
+		redacted[name] = value
+		for _, sensitive := range sensitiveHeaders {
+			if strings.Contains(strings.ToLower(name), sensitive) {
+				redacted[name] = redactedValue
+				break
+			}
+		}
+	}
+	return redacted
+}
+
+func milliseconds(d time.Duration) float64 {
+	return d.Seconds() * 1000
+}
@@ -10,26 +10,46 @@ import (
 )
 
 func ProcessCmdOutput(cmd *exec.Cmd) error {
+	return ProcessCmdOutputFunc(cmd, nil)
+}
+
+// ProcessCmdOutputFunc displays the command's output like ProcessCmdOutput,
+// and also passes each line of its error output to f.
+func ProcessCmdOutputFunc(cmd *exec.Cmd, f func(line string)) error {
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
 		return errors.Wrap(err, "failed to receive stdout pipe")
 	}
 
+	go displayOutput(stdout, nil)
+
+	return ProcessCmdErrorOutputFunc(cmd, f)
+}
+
+func ProcessCmdErrorOutput(cmd *exec.Cmd) error {
+	return ProcessCmdErrorOutputFunc(cmd, nil)
+}
+
+// ProcessCmdErrorOutputFunc displays the command's error output like
+// ProcessCmdErrorOutput, and also passes each line to f.
+func ProcessCmdErrorOutputFunc(cmd *exec.Cmd, f func(line string)) error {
 	stderr, err := cmd.StderrPipe()
 	if err != nil {
 		return errors.Wrap(err, "failed to receive stderr pipe")
 	}
 
-	go displayOutput(stdout)
-	go displayOutput(stderr)
+	go displayOutput(stderr, f)
 
 	return nil
 }
 
-func displayOutput(r io.Reader) {
+func displayOutput(r io.Reader, f func(line string)) {
 	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
 		log.Println(scanner.Text())
+		if f != nil {
+			f(scanner.Text())
+		}
 	}
 
 	if err := scanner.Err(); err != nil {
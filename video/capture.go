@@ -7,7 +7,9 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jordanpotter/site-analyzer/utils"
@@ -17,27 +19,56 @@ import (
 const (
 	captureName       = "capture.mp4"
 	captureStopDelay  = 100 * time.Millisecond
+	captureStartLimit = 10 * time.Second
 	videoFilename     = "video.mp4"
 	videoQuality      = 18
 	thumbnailFilename = "thumbnail.png"
+
+	filmstripDirname      = "filmstrip"
+	filmstripFramePattern = "frame_%04d.png"
+	filmstripFrameGlob    = "frame_*.png"
+	contactSheetFilename  = "contact_sheet.png"
+	contactSheetColumns   = 10
+	contactSheetWidth     = 320
 )
 
+// captureStartRegexp matches the start time ffmpeg reports for its input.
+// x11grab stamps frames with the wall clock, so this is when the first frame
+// was grabbed.
+var captureStartRegexp = regexp.MustCompile(`Duration: .*, start: (\d+\.\d+)`)
+
 type Capture struct {
 	cmd         *exec.Cmd
 	capturePath string
+	width       int
+	height      int
+	firstFrame  time.Time
 }
 
-func StartCapture(ctx context.Context, displayNum, width, height, fps int) (*Capture, error) {
+// StartCapture records the width by height region of the display at x, y.
+// The dimensions are rounded down to even numbers, as the encoder requires.
+func StartCapture(ctx context.Context, displayNum, x, y, width, height, fps int) (*Capture, error) {
 	dir, err := ioutil.TempDir("", "capture")
 	if err != nil {
 		return nil, err
 	}
 
+	width, height = width&^1, height&^1
 	path := filepath.Join(dir, captureName)
-	args := captureArgs(displayNum, width, height, fps, path)
+	args := captureArgs(displayNum, x, y, width, height, fps, path)
 	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
 
-	if err := utils.ProcessCmdOutput(cmd); err != nil {
+	firstFrames := make(chan time.Time, 1)
+	err = utils.ProcessCmdOutputFunc(cmd, func(line string) {
+		if matches := captureStartRegexp.FindStringSubmatch(line); matches != nil {
+			seconds, _ := strconv.ParseFloat(matches[1], 64)
+			select {
+			case firstFrames <- time.Unix(0, int64(seconds*float64(time.Second))):
+			default:
+			}
+		}
+	})
+	if err != nil {
 		return nil, errors.Wrap(err, "failed to process command output")
 	}
 
@@ -45,7 +76,21 @@ func StartCapture(ctx context.Context, displayNum, width, height, fps int) (*Cap
 		return nil, errors.Wrap(err, "failed to start process")
 	}
 
-	return &Capture{cmd, path}, nil
+	// Wait for the first frame, so that nothing happens before the capture
+	select {
+	case firstFrame := <-firstFrames:
+		return &Capture{cmd, path, width, height, firstFrame}, nil
+	case <-time.After(captureStartLimit):
+		utils.MustFunc(cmd.Process.Kill)
+		return nil, errors.New("timed out waiting for the first frame")
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Offset is the location of t in the capture.
+func (c *Capture) Offset(t time.Time) time.Duration {
+	return t.Sub(c.firstFrame)
 }
 
 func (c *Capture) Stop() error {
@@ -83,7 +128,78 @@ func (c *Capture) SaveThumbnail(ctx context.Context, loc time.Duration, dir stri
 	return path, errors.Wrap(err, "failed to run process")
 }
 
-func captureArgs(displayNum, width, height, fps int, dst string) []string {
+// SaveFrame saves the frame at loc to path.
+func (c *Capture) SaveFrame(ctx context.Context, loc time.Duration, path string) error {
+	return runFFmpeg(ctx, thumbnailArgs(loc, c.capturePath, path))
+}
+
+// SaveFilmstrip extracts a frame every interval from start up to end into a
+// filmstrip directory, along with a contact sheet of all the frames labelled
+// with their timestamps from start. An end at or before start extracts frames
+// up to the end of the capture.
+func (c *Capture) SaveFilmstrip(ctx context.Context, interval, start, end time.Duration, dir string) (string, error) {
+	if interval < time.Millisecond {
+		return "", errors.Errorf("invalid filmstrip interval %s", interval)
+	}
+
+	filmstripDir := filepath.Join(dir, filmstripDirname)
+	if err := os.MkdirAll(filmstripDir, 0755); err != nil {
+		return "", errors.Wrapf(err, "failed to create directory %s", filmstripDir)
+	}
+
+	// Frames left by an earlier run in the same directory would otherwise be
+	// counted into this filmstrip
+	if err := removeFrames(filmstripDir); err != nil {
+		return "", err
+	}
+
+	framesPath := filepath.Join(filmstripDir, filmstripFramePattern)
+	if err := runFFmpeg(ctx, filmstripArgs(interval, start, end, c.capturePath, framesPath)); err != nil {
+		return "", errors.Wrap(err, "failed to extract frames")
+	}
+
+	frames, err := filepath.Glob(filepath.Join(filmstripDir, filmstripFrameGlob))
+	if err != nil {
+		return "", errors.Wrap(err, "failed to list frames")
+	} else if len(frames) == 0 {
+		return "", errors.New("no frames extracted")
+	}
+
+	contactSheetPath := filepath.Join(filmstripDir, contactSheetFilename)
+	args := contactSheetArgs(interval, start, end, len(frames), c.capturePath, contactSheetPath)
+	if err := runFFmpeg(ctx, args); err != nil {
+		return "", errors.Wrap(err, "failed to create contact sheet")
+	}
+
+	return filmstripDir, nil
+}
+
+func removeFrames(dir string) error {
+	frames, err := filepath.Glob(filepath.Join(dir, filmstripFrameGlob))
+	if err != nil {
+		return errors.Wrap(err, "failed to list frames")
+	}
+
+	for _, frame := range frames {
+		if err := os.Remove(frame); err != nil {
+			return errors.Wrapf(err, "failed to remove frame %s", frame)
+		}
+	}
+	return nil
+}
+
+func runFFmpeg(ctx context.Context, args []string) error {
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+
+	if err := utils.ProcessCmdOutput(cmd); err != nil {
+		return errors.Wrap(err, "failed to process command output")
+	}
+
+	err := cmd.Run()
+	return errors.Wrap(err, "failed to run process")
+}
+
+func captureArgs(displayNum, x, y, width, height, fps int, dst string) []string {
 	// WARNING: the order of arguments is very delicate
 	var args []string
 
@@ -93,8 +209,8 @@ func captureArgs(displayNum, width, height, fps int, dst string) []string {
 	// Source dimensions and framerate
 	args = append(args, "-video_size", fmt.Sprintf("%dx%d", width, height), "-framerate", strconv.Itoa(fps))
 
-	// Source from X11, hide mouse
-	args = append(args, "-f", "x11grab", "-draw_mouse", "0", "-i", fmt.Sprintf(":%d.0", displayNum))
+	// Source from X11 at the region's offset, hide mouse
+	args = append(args, "-f", "x11grab", "-draw_mouse", "0", "-i", fmt.Sprintf(":%d.0+%d,%d", displayNum, x, y))
 
 	// Output codec with parameters
 	args = append(args, "-c:v", "libx264", "-preset", "ultrafast", "-crf", "0", "-pix_fmt", "yuv420p")
@@ -102,8 +218,8 @@ func captureArgs(displayNum, width, height, fps int, dst string) []string {
 	// Disable audio
 	args = append(args, "-an")
 
-	// Log level
-	args = append(args, "-loglevel", "warning")
+	// Log level, including the input information with the first frame's time
+	args = append(args, "-loglevel", "info", "-nostats")
 
 	// Destination
 	args = append(args, dst)
@@ -153,5 +269,81 @@ func thumbnailArgs(loc time.Duration, src, dst string) []string {
 	args = append(args, dst)
 
 	return args
+}
+
+func filmstripArgs(interval, start, end time.Duration, src, dst string) []string {
+	// WARNING: the order of arguments is very delicate
+	var args []string
+
+	// Seek to the start of the filmstrip
+	if start > 0 {
+		args = append(args, "-ss", strconv.FormatFloat(start.Seconds(), 'f', -1, 64))
+	}
+
+	// Source
+	args = append(args, "-i", src)
+
+	// Stop at the end of the filmstrip
+	if end > start {
+		args = append(args, "-t", strconv.FormatFloat((end-start).Seconds(), 'f', -1, 64))
+	}
+
+	// One frame per interval
+	args = append(args, "-vf", "fps="+intervalRate(interval))
+
+	// Log level
+	args = append(args, "-loglevel", "warning")
+
+	// Destination
+	args = append(args, dst)
+
+	return args
+}
+
+func contactSheetArgs(interval, start, end time.Duration, frames int, src, dst string) []string {
+	// WARNING: the order of arguments is very delicate
+	var args []string
+
+	// Seek to the start of the filmstrip
+	if start > 0 {
+		args = append(args, "-ss", strconv.FormatFloat(start.Seconds(), 'f', -1, 64))
+	}
+
+	// Source
+	args = append(args, "-i", src)
+
+	// Stop at the end of the filmstrip
+	if end > start {
+		args = append(args, "-t", strconv.FormatFloat((end-start).Seconds(), 'f', -1, 64))
+	}
+
+	// One frame per interval, labelled with its timestamp and tiled into a grid
+	columns := contactSheetColumns
+	if frames < columns {
+		columns = frames
+	}
+	rows := (frames + columns - 1) / columns
+	filters := []string{
+		"fps=" + intervalRate(interval),
+		"drawtext=text='%{pts\\:hms}':x=10:y=10:fontsize=36:fontcolor=white:box=1:boxcolor=black@0.6",
+		fmt.Sprintf("scale=%d:-2", contactSheetWidth),
+		fmt.Sprintf("tile=%dx%d:padding=4:margin=4", columns, rows),
+	}
+	args = append(args, "-vf", strings.Join(filters, ","))
+
+	// Only output the single tiled image
+	args = append(args, "-vframes", "1")
+
+	// Log level
+	args = append(args, "-loglevel", "warning")
+
+	// Destination
+	args = append(args, dst)
+
+	return args
+}
 
+// intervalRate expresses a frame interval as a rational frame rate.
+func intervalRate(interval time.Duration) string {
+	return fmt.Sprintf("%d/%d", time.Second/time.Microsecond, interval/time.Microsecond)
 }
@@ -0,0 +1,33 @@
+package video
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// FFmpegVersion returns the version reported by the ffmpeg binary used for
+// capturing and encoding.
+func FFmpegVersion(ctx context.Context) (string, error) {
+	out, err := exec.CommandContext(ctx, "ffmpeg", "-version").Output()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to run process")
+	}
+
+	// The first line is of the form "ffmpeg version <version> Copyright ..."
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	if !scanner.Scan() {
+		return "", errors.New("empty version output")
+	}
+
+	fields := strings.Fields(scanner.Text())
+	if len(fields) < 3 || fields[1] != "version" {
+		return "", errors.Errorf("unexpected version output %q", scanner.Text())
+	}
+
+	return fields[2], nil
+}
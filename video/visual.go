@@ -0,0 +1,225 @@
+package video
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/jordanpotter/site-analyzer/utils"
+	"github.com/pkg/errors"
+)
+
+const (
+	visualSampleFPS    = 10
+	visualScaleDivisor = 4
+	histogramBuckets   = 256
+	histogramSlop      = 5
+	histogramWhite     = 250
+)
+
+// VisualProgress describes how quickly the visible page approached its final
+// state, in the manner of WebPageTest. Times are relative to navigation start,
+// so frames captured ahead of it have negative times and do not count.
+type VisualProgress struct {
+	Frames            []VisualFrame
+	SpeedIndex        time.Duration
+	FirstVisualChange time.Duration
+	LastVisualChange  time.Duration
+}
+
+// VisualFrame is the completeness of a sampled frame, from 0 to 1, compared
+// against the frame where the page finished loading.
+type VisualFrame struct {
+	Time         time.Duration
+	Completeness float64
+}
+
+type histogram [3][histogramBuckets]int
+
+// AnalyzeVisualProgress measures the visual progress of the page, where origin
+// is the location of navigation start in the capture and end is where the page
+// finished loading. The frame at end is the final state the others are
+// compared against, so that anything captured afterwards does not count. An
+// end of zero analyzes the whole capture.
+func (c *Capture) AnalyzeVisualProgress(ctx context.Context, origin, end time.Duration) (*VisualProgress, error) {
+	width, height := scaledDimension(c.width), scaledDimension(c.height)
+	args := visualArgs(end, c.capturePath, width, height)
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to receive stdout pipe")
+	}
+
+	if err = utils.ProcessCmdErrorOutput(cmd); err != nil {
+		return nil, errors.Wrap(err, "failed to process command output")
+	}
+
+	if err = cmd.Start(); err != nil {
+		return nil, errors.Wrap(err, "failed to start process")
+	}
+
+	histograms, err := readHistograms(stdout, width, height)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read frames")
+	}
+
+	if err = cmd.Wait(); err != nil {
+		return nil, errors.Wrap(err, "failed to run process")
+	}
+
+	if len(histograms) == 0 {
+		return nil, errors.New("capture contains no frames")
+	}
+
+	return visualProgress(histograms, origin), nil
+}
+
+func readHistograms(r io.Reader, width, height int) ([]*histogram, error) {
+	var histograms []*histogram
+	frame := make([]byte, width*height*3)
+	for {
+		if _, err := io.ReadFull(r, frame); err == io.EOF {
+			return histograms, nil
+		} else if err != nil {
+			return nil, errors.Wrap(err, "failed to read frame")
+		}
+		histograms = append(histograms, frameHistogram(frame))
+	}
+}
+
+// frameHistogram counts the pixels of an rgb24 frame per channel value.
+// White pixels are ignored, so that the blank page before anything renders
+// does not contribute to the progress calculation.
+func frameHistogram(frame []byte) *histogram {
+	var h histogram
+	for i := 0; i+2 < len(frame); i += 3 {
+		r, g, b := frame[i], frame[i+1], frame[i+2]
+		if r > histogramWhite && g > histogramWhite && b > histogramWhite {
+			continue
+		}
+		h[0][r]++
+		h[1][g]++
+		h[2][b]++
+	}
+	return &h
+}
+
+func visualProgress(histograms []*histogram, origin time.Duration) *VisualProgress {
+	start, final := histograms[0], histograms[len(histograms)-1]
+	interval := time.Second / visualSampleFPS
+
+	progress := &VisualProgress{Frames: make([]VisualFrame, 0, len(histograms))}
+	for i, h := range histograms {
+		frame := VisualFrame{
+			Time:         time.Duration(i)*interval - origin,
+			Completeness: completeness(h, start, final),
+		}
+
+		if i > 0 && frame.Time > 0 {
+			prev := progress.Frames[i-1]
+			elapsed := interval
+			if prev.Time < 0 {
+				elapsed = frame.Time
+			}
+			progress.SpeedIndex += time.Duration((1 - prev.Completeness) * float64(elapsed))
+
+			if frame.Completeness != prev.Completeness {
+				if progress.FirstVisualChange == 0 {
+					progress.FirstVisualChange = frame.Time
+				}
+				progress.LastVisualChange = frame.Time
+			}
+		}
+
+		progress.Frames = append(progress.Frames, frame)
+	}
+
+	return progress
+}
+
+// completeness compares how far each channel histogram has moved from the
+// start histogram towards the final histogram. Pixels are allowed to match
+// buckets within histogramSlop of each other to absorb rendering noise.
+func completeness(current, start, final *histogram) float64 {
+	var total, matched int
+	for channel := range current {
+		var available [histogramBuckets]int
+		for i := range available {
+			available[i] = abs(current[channel][i] - start[channel][i])
+		}
+
+		for i := 0; i < histogramBuckets; i++ {
+			target := abs(final[channel][i] - start[channel][i])
+			total += target
+
+			low, high := max(0, i-histogramSlop), min(histogramBuckets-1, i+histogramSlop)
+			for j := low; j <= high && target > 0; j++ {
+				m := min(available[j], target)
+				available[j] -= m
+				target -= m
+				matched += m
+			}
+		}
+	}
+
+	if total == 0 {
+		return 1
+	}
+	return float64(matched) / float64(total)
+}
+
+func scaledDimension(d int) int {
+	return max(1, d/visualScaleDivisor)
+}
+
+func visualArgs(end time.Duration, src string, width, height int) []string {
+	// WARNING: the order of arguments is very delicate
+	var args []string
+
+	// Source
+	args = append(args, "-i", src)
+
+	// Stop where the page finished loading
+	if end > 0 {
+		args = append(args, "-t", strconv.FormatFloat(end.Seconds(), 'f', -1, 64))
+	}
+
+	// Sample and shrink frames
+	args = append(args, "-vf", fmt.Sprintf("fps=%d,scale=%d:%d", visualSampleFPS, width, height))
+
+	// Output raw frames
+	args = append(args, "-f", "rawvideo", "-pix_fmt", "rgb24")
+
+	// Log level
+	args = append(args, "-loglevel", "warning")
+
+	// Destination
+	args = append(args, "pipe:1")
+
+	return args
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}